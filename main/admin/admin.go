@@ -0,0 +1,166 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"learnLoop/main/auth"
+	"learnLoop/main/models"
+)
+
+// Paths of the administration API
+const (
+	RevocationsPath = "/admin/revocations"
+	RejectionsPath  = "/admin/rejections"
+)
+
+// Handler serves the administration API to the users whose token grants the
+// admin permission
+type Handler struct {
+	Revocations *auth.RevocationStore
+}
+
+// administrator is the authenticated user of the administration API
+type administrator struct {
+	*auth.Identity
+	// global administrators manage every instance, the others only the
+	// instance of their token
+	global bool
+}
+
+// manages tells whether the administrator may manage the instance, an
+// empty instance name standing for every instance
+func (a *administrator) manages(instanceName string) bool {
+	return a.global || (instanceName != "" && instanceName == a.InstanceName)
+}
+
+// authorize checks that the request carries the token of an administrator
+func authorize(w http.ResponseWriter, r *http.Request) (*administrator, bool) {
+	token := auth.BearerToken(r)
+	if token == "" {
+		http.Error(w, "Missing authorization token", http.StatusUnauthorized)
+		return nil, false
+	}
+	identity, err := auth.ValidateJWT(token)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+	_, permissions := models.NewPermissions(identity.Roles)
+	global := permissions[models.PERMISSION_ADMIN_GLOBAL]
+	if !global && !permissions[models.PERMISSION_ADMIN] {
+		log.Printf("User %s is not allowed to use the administration API", identity.UserID)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil, false
+	}
+	return &administrator{Identity: identity, global: global}, true
+}
+
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Printf("Error writing admin response: %v", err)
+	}
+}
+
+// ServeRevocations lists the revocations on GET, adds a revocation on POST
+// and removes the revocation given by the kind, instanceName and value query
+// parameters on DELETE. Adding a revocation closes the matching connections.
+// The instance of a revocation is the instance of the administrator by
+// default, only global administrators manage the other instances.
+func (h *Handler) ServeRevocations(w http.ResponseWriter, r *http.Request) {
+	admin, ok := authorize(w, r)
+	if !ok {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		revocations := []auth.Revocation{}
+		for _, revocation := range h.Revocations.List() {
+			if admin.manages(revocation.InstanceName) {
+				revocations = append(revocations, revocation)
+			}
+		}
+		writeJSON(w, http.StatusOK, revocations)
+	case http.MethodPost:
+		var revocation auth.Revocation
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&revocation); err != nil {
+			http.Error(w, "Invalid revocation: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if revocation.InstanceName == "" && !admin.global {
+			revocation.InstanceName = admin.InstanceName
+		}
+		if !admin.manages(revocation.InstanceName) {
+			log.Printf("User %s is not allowed to revoke on instance %s", admin.UserID, revocation.InstanceName)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		revocation.RevokedAt = time.Now()
+		if err := h.Revocations.Revoke(revocation); err != nil {
+			log.Printf("Error revoking %s %s: %v", revocation.Kind, revocation.Value, err)
+			status := http.StatusInternalServerError
+			if errors.Is(err, auth.ErrInvalidRevocation) {
+				status = http.StatusBadRequest
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		log.Printf(
+			"Revoked %s %s on instance %q: %s",
+			revocation.Kind, revocation.Value, revocation.InstanceName, revocation.Reason,
+		)
+		writeJSON(w, http.StatusCreated, revocation)
+	case http.MethodDelete:
+		kind := auth.RevocationKind(r.URL.Query().Get("kind"))
+		value := r.URL.Query().Get("value")
+		instanceName := r.URL.Query().Get("instanceName")
+		if instanceName == "" && !admin.global {
+			instanceName = admin.InstanceName
+		}
+		if !admin.manages(instanceName) {
+			log.Printf("User %s is not allowed to remove revocations on instance %s", admin.UserID, instanceName)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		removed, err := h.Revocations.Unrevoke(kind, instanceName, value)
+		if err != nil {
+			log.Printf("Error removing revocation of %s %s: %v", kind, value, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if !removed {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Removed revocation of %s %s on instance %q", kind, value, instanceName)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// ServeRejections returns the number of rejected tokens by reason on GET.
+// The counts cover every instance, they are served to global administrators
+// only.
+func (h *Handler) ServeRejections(w http.ResponseWriter, r *http.Request) {
+	admin, ok := authorize(w, r)
+	if !ok {
+		return
+	}
+	if !admin.global {
+		log.Printf("User %s is not allowed to read the rejection counts", admin.UserID)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, auth.RejectionCounts())
+}
@@ -0,0 +1,126 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"learnLoop/main/auth"
+	"learnLoop/main/config"
+)
+
+// adminServer serves the administration API and the JWKS of a development
+// identity provider whose issuer is trusted
+func adminServer(t *testing.T) (*httptest.Server, *auth.DevIdentityProvider, *auth.RevocationStore) {
+	t.Helper()
+	previousDevMode := config.DevMode
+	config.DevMode = true
+	t.Cleanup(func() { config.DevMode = previousDevMode })
+
+	store, err := auth.NewRevocationStore("")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	handler := &Handler{Revocations: store}
+	mux := http.NewServeMux()
+	mux.HandleFunc(RevocationsPath, handler.ServeRevocations)
+	mux.HandleFunc(RejectionsPath, handler.ServeRejections)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	issuer, err := auth.DevIssuer(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	provider, err := auth.NewDevIdentityProvider(issuer)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	mux.HandleFunc(auth.DevJWKSPath, provider.ServeJWKS)
+	if err := auth.LoadTrustedIssuers([]config.IssuerConfig{{Issuer: issuer}}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	return server, provider, store
+}
+
+func TestServeRevocations(t *testing.T) {
+	server, provider, store := adminServer(t)
+	mint := func(role string, instanceName string) string {
+		token, err := provider.MintToken(auth.DevTokenRequest{Subject: "admin", Role: role, InstanceName: instanceName})
+		if err != nil {
+			t.Fatalf("Failed to mint token: %v", err)
+		}
+		return token
+	}
+	// adminToken is the token of an administrator of the dev instance
+	adminToken := mint("admin", "dev")
+	globalToken := mint("admin:global", "dev")
+
+	request := func(method string, path string, token string, body string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		body   string
+		status int
+	}{
+		{"Without token", http.MethodGet, RevocationsPath, "", "", http.StatusUnauthorized},
+		{"Without admin permission", http.MethodGet, RevocationsPath, mint("facilitator", "dev"), "", http.StatusForbidden},
+		{"Ban a user", http.MethodPost, RevocationsPath, adminToken, `{"kind":"user","value":"mallory","reason":"spam"}`, http.StatusCreated},
+		{"Ban a user of another instance", http.MethodPost, RevocationsPath, adminToken, `{"kind":"user","value":"mallory","instanceName":"other"}`, http.StatusForbidden},
+		{"Ban a user of another instance as global administrator", http.MethodPost, RevocationsPath, globalToken, `{"kind":"user","value":"mallory","instanceName":"other"}`, http.StatusCreated},
+		{"Ban a user without instance", http.MethodPost, RevocationsPath, globalToken, `{"kind":"user","value":"mallory"}`, http.StatusBadRequest},
+		{"Revoke a token", http.MethodPost, RevocationsPath, adminToken, `{"kind":"jti","value":"token1"}`, http.StatusCreated},
+		{"Unknown kind", http.MethodPost, RevocationsPath, adminToken, `{"kind":"ip","value":"127.0.0.1"}`, http.StatusBadRequest},
+		{"Invalid body", http.MethodPost, RevocationsPath, adminToken, `{`, http.StatusBadRequest},
+		{"Remove a revocation", http.MethodDelete, RevocationsPath + "?kind=jti&value=token1", adminToken, "", http.StatusNoContent},
+		{"Remove a missing revocation", http.MethodDelete, RevocationsPath + "?kind=jti&value=token1", adminToken, "", http.StatusNotFound},
+		{"Remove a revocation of another instance", http.MethodDelete, RevocationsPath + "?kind=user&value=mallory&instanceName=other", adminToken, "", http.StatusForbidden},
+		{"Unsupported method", http.MethodPut, RevocationsPath, adminToken, "", http.StatusMethodNotAllowed},
+		{"Rejection counts", http.MethodGet, RejectionsPath, adminToken, "", http.StatusForbidden},
+		{"Rejection counts as global administrator", http.MethodGet, RejectionsPath, globalToken, "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if resp := request(tt.method, tt.path, tt.token, tt.body); resp.StatusCode != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, resp.StatusCode)
+			}
+		})
+	}
+
+	listRevocations := func(token string) []auth.Revocation {
+		t.Helper()
+		var revocations []auth.Revocation
+		if err := json.NewDecoder(request(http.MethodGet, RevocationsPath, token, "").Body).Decode(&revocations); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		return revocations
+	}
+	revocations := listRevocations(adminToken)
+	if len(revocations) != 1 || revocations[0].Value != "mallory" || revocations[0].InstanceName != "dev" ||
+		revocations[0].RevokedAt.IsZero() {
+		t.Errorf("Expected the ban of mallory on dev to be the only revocation of dev, got %+v", revocations)
+	}
+	if revocations := listRevocations(globalToken); len(revocations) != 2 {
+		t.Errorf("Expected the revocations of every instance, got %+v", revocations)
+	}
+	if store.Revoked("", "mallory", "dev") == nil {
+		t.Error("Expected mallory to be banned")
+	}
+}
@@ -0,0 +1,217 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"learnLoop/main/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// DevJWKSPath is the path of the JWKS of the development identity provider
+	DevJWKSPath = "/.well-known/jwks.json"
+	// DevTokenPath is the path of the token endpoint of the development identity provider
+	DevTokenPath = "/dev/token"
+
+	devDefaultTokenLifetime = time.Hour
+	devDefaultInstanceName  = "dev"
+)
+
+// DevIdentityProvider is an identity provider for development, so that the
+// server can be used without any outside service. It signs tokens with a key
+// generated at startup and serves the matching JWKS.
+type DevIdentityProvider struct {
+	issuer string
+	kid    string
+	key    *ecdsa.PrivateKey
+}
+
+// DevTokenRequest describes the token to mint
+type DevTokenRequest struct {
+	Subject string
+	Role    string
+	// InstanceName is the instanceBaseName claim, dev if empty
+	InstanceName string
+	Login        string
+	Name         string
+	// ExpiresIn is the lifetime of the token, one hour if zero
+	ExpiresIn time.Duration
+}
+
+// DevIssuer returns the did:web issuer of the development identity provider
+// of a server listening on addr, whose JWKS URL is the DevJWKSPath of that server
+func DevIssuer(addr string) (string, error) {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("invalid address %s: %w", addr, err)
+	}
+	return "did:web:localhost:" + port, nil
+}
+
+// NewDevIdentityProvider generates a new signing key for the given issuer
+func NewDevIdentityProvider(issuer string) (*DevIdentityProvider, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %w", err)
+	}
+	kid := make([]byte, 8)
+	if _, err := rand.Read(kid); err != nil {
+		return nil, fmt.Errorf("failed to generate key ID: %w", err)
+	}
+	return &DevIdentityProvider{
+		issuer: issuer,
+		kid:    "dev-" + base64.RawURLEncoding.EncodeToString(kid),
+		key:    key,
+	}, nil
+}
+
+// Issuer returns the issuer of the minted tokens
+func (p *DevIdentityProvider) Issuer() string {
+	return p.issuer
+}
+
+// JWKS returns the public key set of the provider
+func (p *DevIdentityProvider) JWKS() JWKS {
+	return JWKS{Keys: []JWK{{
+		Kid: p.kid,
+		Kty: "EC",
+		Alg: jwt.SigningMethodES256.Alg(),
+		Use: "sig",
+		Crv: "P-256",
+		X:   base64.RawURLEncoding.EncodeToString(p.key.X.FillBytes(make([]byte, 32))),
+		Y:   base64.RawURLEncoding.EncodeToString(p.key.Y.FillBytes(make([]byte, 32))),
+	}}}
+}
+
+// IssuerConfig returns the configuration trusting the provider with its
+// public key, so that its JWKS is never fetched
+func (p *DevIdentityProvider) IssuerConfig() (config.IssuerConfig, error) {
+	der, err := x509.MarshalPKIXPublicKey(&p.key.PublicKey)
+	if err != nil {
+		return config.IssuerConfig{}, fmt.Errorf("failed to encode public key: %w", err)
+	}
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	return config.IssuerConfig{Issuer: p.issuer, PublicKeys: map[string]string{p.kid: string(pemKey)}}, nil
+}
+
+// MintToken returns a signed token with the requested claims
+func (p *DevIdentityProvider) MintToken(request DevTokenRequest) (string, error) {
+	if strings.TrimSpace(request.Subject) == "" {
+		return "", errors.New("subject is required")
+	}
+	expiresIn := request.ExpiresIn
+	if expiresIn == 0 {
+		expiresIn = devDefaultTokenLifetime
+	}
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", fmt.Errorf("failed to generate token ID: %w", err)
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":              p.issuer,
+		"sub":              request.Subject,
+		"iat":              now.Unix(),
+		"nbf":              now.Unix(),
+		"exp":              now.Add(expiresIn).Unix(),
+		"jti":              base64.RawURLEncoding.EncodeToString(jti),
+		"instanceBaseName": devDefaultInstanceName,
+	}
+	if request.InstanceName != "" {
+		claims["instanceBaseName"] = request.InstanceName
+	}
+	if audiences := config.TokenPolicy.Audiences; len(audiences) > 0 {
+		claims["aud"] = audiences
+	}
+	if request.Role != "" {
+		claims[config.RoleClaim] = request.Role
+	}
+	if request.Login != "" {
+		claims[config.LoginClaim] = request.Login
+	}
+	if request.Name != "" {
+		claims[config.NameClaim] = request.Name
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["kid"] = p.kid
+	return token.SignedString(p.key)
+}
+
+// ServeJWKS serves the public key set of the provider
+func (p *DevIdentityProvider) ServeJWKS(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(p.JWKS()); err != nil {
+		log.Printf("Error writing JWKS: %v", err)
+	}
+}
+
+// ServeToken mints a token from the sub, role, instanceBaseName, login, name
+// and expiresIn (a duration like 2h) form values. Only local clients can
+// mint tokens.
+func (p *DevIdentityProvider) ServeToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !isLoopback(r.RemoteAddr) {
+		log.Printf("Rejected dev token request from %s", r.RemoteAddr)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	request := DevTokenRequest{
+		Subject:      r.FormValue("sub"),
+		Role:         r.FormValue("role"),
+		InstanceName: r.FormValue("instanceBaseName"),
+		Login:        r.FormValue("login"),
+		Name:         r.FormValue("name"),
+	}
+	if expiresIn := r.FormValue("expiresIn"); expiresIn != "" {
+		duration, err := time.ParseDuration(expiresIn)
+		if err != nil || duration <= 0 {
+			http.Error(w, "invalid expiresIn: "+expiresIn, http.StatusBadRequest)
+			return
+		}
+		request.ExpiresIn = duration
+	}
+	token, err := p.MintToken(request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]string{"token": token}); err != nil {
+		log.Printf("Error writing dev token: %v", err)
+	}
+}
+
+// isLoopback tells if a remote address is a loopback address
+func isLoopback(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"learnLoop/main/config"
+)
+
+// devServer serves the JWKS and the token endpoint of a new development
+// identity provider, whose issuer is trusted
+func devServer(t *testing.T) (*httptest.Server, *DevIdentityProvider) {
+	t.Helper()
+	previousDevMode := config.DevMode
+	config.DevMode = true
+	t.Cleanup(func() { config.DevMode = previousDevMode })
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	issuer, err := DevIssuer(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	provider, err := NewDevIdentityProvider(issuer)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	mux.HandleFunc(DevJWKSPath, provider.ServeJWKS)
+	mux.HandleFunc(DevTokenPath, provider.ServeToken)
+	if err := LoadTrustedIssuers([]config.IssuerConfig{{Issuer: issuer}}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	return server, provider
+}
+
+func TestDevIdentityProvider(t *testing.T) {
+	server, provider := devServer(t)
+
+	t.Run("Minted token is valid", func(t *testing.T) {
+		token, err := provider.MintToken(DevTokenRequest{
+			Subject:      "alice",
+			Role:         "facilitator",
+			InstanceName: "instance1",
+			Name:         "Alice",
+		})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		identity, err := ValidateJWT(token)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		expected := Identity{
+			UserID:       "alice",
+			Login:        "alice",
+			DisplayName:  "Alice",
+			InstanceName: "instance1",
+			Roles:        []string{"facilitator"},
+		}
+		if identity.UserID != expected.UserID || identity.Login != expected.Login ||
+			identity.DisplayName != expected.DisplayName || identity.InstanceName != expected.InstanceName ||
+			len(identity.Roles) != 1 || identity.Roles[0] != "facilitator" {
+			t.Errorf("Expected identity %+v, got %+v", expected, *identity)
+		}
+	})
+
+	t.Run("Expired token is rejected", func(t *testing.T) {
+		token, err := provider.MintToken(DevTokenRequest{Subject: "alice", ExpiresIn: -time.Minute})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if _, err := ValidateJWT(token); err == nil {
+			t.Error("Expected expired token to be rejected")
+		}
+	})
+
+	t.Run("Subject is required", func(t *testing.T) {
+		if _, err := provider.MintToken(DevTokenRequest{}); err == nil {
+			t.Error("Expected error without subject")
+		}
+	})
+
+	t.Run("Token endpoint", func(t *testing.T) {
+		resp, err := http.PostForm(server.URL+DevTokenPath, url.Values{
+			"sub":              {"bob"},
+			"role":             {"learner"},
+			"instanceBaseName": {"instance2"},
+			"expiresIn":        {"2h"},
+		})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+		}
+		var body struct {
+			Token string `json:"token"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		identity, err := ValidateJWT(body.Token)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if identity.UserID != "bob" || identity.InstanceName != "instance2" {
+			t.Errorf("Unexpected identity %+v", *identity)
+		}
+	})
+}
+
+func TestDevIdentityProviderIssuerConfig(t *testing.T) {
+	// nothing listens on the address of the issuer, its JWKS cannot be fetched
+	issuer, err := DevIssuer("127.0.0.1:1")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	provider, err := NewDevIdentityProvider(issuer)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	issuerConfig, err := provider.IssuerConfig()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := LoadTrustedIssuers([]config.IssuerConfig{issuerConfig}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	token, err := provider.MintToken(DevTokenRequest{Subject: "alice"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := ValidateJWT(token); err != nil {
+		t.Errorf("Expected token to be verified with the static key, got %v", err)
+	}
+}
+
+func TestDevIdentityProviderServeToken(t *testing.T) {
+	provider, err := NewDevIdentityProvider("did:web:localhost:8080")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	tests := []struct {
+		name       string
+		method     string
+		remoteAddr string
+		query      string
+		status     int
+	}{
+		{"Local client", http.MethodPost, "127.0.0.1:1234", "sub=alice", http.StatusOK},
+		{"Remote client", http.MethodPost, "192.168.1.10:1234", "sub=alice", http.StatusForbidden},
+		{"GET is not allowed", http.MethodGet, "127.0.0.1:1234", "sub=alice", http.StatusMethodNotAllowed},
+		{"Missing subject", http.MethodPost, "127.0.0.1:1234", "", http.StatusBadRequest},
+		{"Invalid expiry", http.MethodPost, "127.0.0.1:1234", "sub=alice&expiresIn=soon", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(tt.method, DevTokenPath+"?"+tt.query, strings.NewReader(""))
+			request.RemoteAddr = tt.remoteAddr
+			recorder := httptest.NewRecorder()
+			provider.ServeToken(recorder, request)
+			if recorder.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, recorder.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"learnLoop/main/config"
+)
+
+// trustedIssuer is an issuer whose tokens are accepted, with either the
+// cache of its JWKS or its static public keys
+type trustedIssuer struct {
+	issuer     string
+	jwks       *jwksCache
+	staticKeys map[string]crypto.PublicKey
+}
+
+var (
+	trustedIssuers    map[string]*trustedIssuer
+	trustedIssuersMux sync.RWMutex
+)
+
+// LoadTrustedIssuers replaces the trusted issuers.
+// Tokens of any other issuer are rejected without any network call.
+func LoadTrustedIssuers(issuerConfigs []config.IssuerConfig) error {
+	issuers := make(map[string]*trustedIssuer)
+	for _, issuerConfig := range issuerConfigs {
+		issuer, err := newTrustedIssuer(issuerConfig)
+		if err != nil {
+			return fmt.Errorf("invalid trusted issuer %s: %w", issuerConfig.Issuer, err)
+		}
+		issuers[issuer.issuer] = issuer
+	}
+
+	trustedIssuersMux.Lock()
+	previous := trustedIssuers
+	trustedIssuers = issuers
+	trustedIssuersMux.Unlock()
+
+	// stop the background refresh of the replaced issuers
+	for _, issuer := range previous {
+		if issuer.jwks != nil {
+			issuer.jwks.close()
+		}
+	}
+	return nil
+}
+
+func newTrustedIssuer(issuerConfig config.IssuerConfig) (*trustedIssuer, error) {
+	if issuerConfig.Issuer == "" {
+		return nil, errors.New("issuer is empty")
+	}
+	issuer := &trustedIssuer{
+		issuer:     issuerConfig.Issuer,
+		staticKeys: make(map[string]crypto.PublicKey),
+	}
+	for kid, pemKey := range issuerConfig.PublicKeys {
+		key, err := parsePEMPublicKey([]byte(pemKey))
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key %s: %w", kid, err)
+		}
+		issuer.staticKeys[kid] = key
+	}
+	if len(issuer.staticKeys) > 0 {
+		return issuer, nil
+	}
+	jwksURL := issuerConfig.JWKSURL
+	if jwksURL == "" {
+		var err error
+		jwksURL, err = buildPublicKeyURL(issuer.issuer)
+		if err != nil {
+			return nil, fmt.Errorf("no JWKS URL nor public keys: %w", err)
+		}
+	}
+	issuer.jwks = newJWKSCache(jwksURL)
+	return issuer, nil
+}
+
+// findTrustedIssuer returns the trusted issuer with the given name
+func findTrustedIssuer(issuer string) (*trustedIssuer, error) {
+	trustedIssuersMux.RLock()
+	defer trustedIssuersMux.RUnlock()
+	trusted, ok := trustedIssuers[issuer]
+	if !ok {
+		return nil, fmt.Errorf("issuer %s is not trusted", issuer)
+	}
+	return trusted, nil
+}
+
+// publicKey returns the key of the issuer with the given key ID
+func (issuer *trustedIssuer) publicKey(kid string) (crypto.PublicKey, error) {
+	if issuer.jwks != nil {
+		return issuer.jwks.key(kid)
+	}
+	if key, ok := issuer.staticKeys[kid]; ok {
+		return key, nil
+	}
+	if kid == "" && len(issuer.staticKeys) == 1 {
+		for _, key := range issuer.staticKeys {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("no public key with ID %s for issuer %s", kid, issuer.issuer)
+}
+
+// fetchJWKS fetches the JWKS document at url and returns it with the
+// response headers, with a timeout and a limited response size, without
+// following redirects
+func fetchJWKS(url string) ([]byte, http.Header, error) {
+	client := &http.Client{
+		Timeout: config.JWKSFetchTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("failed to fetch JWKS, status code: %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, config.JWKSMaxResponseBytes+1))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read JWKS response: %w", err)
+	}
+	if int64(len(body)) > config.JWKSMaxResponseBytes {
+		return nil, nil, fmt.Errorf("JWKS response exceeds %d bytes", config.JWKSMaxResponseBytes)
+	}
+	return body, resp.Header, nil
+}
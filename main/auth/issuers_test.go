@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"learnLoop/main/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func newRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	return key
+}
+
+func rsaJWK(kid string, key *rsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kid": kid,
+		"kty": "RSA",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func signToken(t *testing.T, method jwt.SigningMethod, key any, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	return signed
+}
+
+func validClaims(issuer string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":                issuer,
+		"sub":                "did:web:localhost/did/user1",
+		"instanceBaseName":   "instance1",
+		"preferred_username": "jdoe",
+		"exp":                time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+// jwksServer serves the given keys as a JWKS and counts the requests
+func jwksServer(t *testing.T, keys ...any) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	requests := &atomic.Int32{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func TestValidateJWTTrustedIssuers(t *testing.T) {
+	key := newRSAKey(t)
+	server, requests := jwksServer(t, rsaJWK("key1", &key.PublicKey))
+	err := LoadTrustedIssuers([]config.IssuerConfig{
+		{Issuer: "did:web:trusted", JWKSURL: server.URL},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	t.Run("Trusted issuer", func(t *testing.T) {
+		identity, err := ValidateJWT(signToken(t, jwt.SigningMethodRS256, key, "key1", validClaims("did:web:trusted")))
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if identity.UserID != "user1" || identity.Login != "jdoe" || identity.InstanceName != "instance1" {
+			t.Errorf("Unexpected identity %+v", identity)
+		}
+	})
+
+	t.Run("Unknown issuer rejected without network call", func(t *testing.T) {
+		before := requests.Load()
+		_, err := ValidateJWT(signToken(t, jwt.SigningMethodRS256, key, "key1", validClaims("did:web:attacker:8443")))
+		if err == nil || !strings.Contains(err.Error(), "is not trusted") {
+			t.Fatalf("Expected untrusted issuer error, got %v", err)
+		}
+		if requests.Load() != before {
+			t.Error("Expected no JWKS request for an untrusted issuer")
+		}
+	})
+
+	t.Run("Token signed by another key", func(t *testing.T) {
+		otherKey := newRSAKey(t)
+		_, err := ValidateJWT(signToken(t, jwt.SigningMethodRS256, otherKey, "key1", validClaims("did:web:trusted")))
+		if err == nil {
+			t.Fatal("Expected signature error")
+		}
+	})
+}
+
+func TestValidateJWTStaticKeys(t *testing.T) {
+	key := newRSAKey(t)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+	pemKey := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	err = LoadTrustedIssuers([]config.IssuerConfig{
+		{Issuer: "static-issuer", PublicKeys: map[string]string{"static1": pemKey}},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if _, err := ValidateJWT(signToken(t, jwt.SigningMethodRS256, key, "static1", validClaims("static-issuer"))); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	if _, err := ValidateJWT(signToken(t, jwt.SigningMethodRS256, key, "", validClaims("static-issuer"))); err != nil {
+		t.Errorf("Expected single static key to be used without kid, got %v", err)
+	}
+	if _, err := ValidateJWT(signToken(t, jwt.SigningMethodRS256, key, "unknown", validClaims("static-issuer"))); err == nil {
+		t.Error("Expected unknown key ID to be rejected")
+	}
+
+	err = LoadTrustedIssuers([]config.IssuerConfig{
+		{Issuer: "static-issuer", PublicKeys: map[string]string{"static1": "not a key"}},
+	})
+	if err == nil {
+		t.Error("Expected invalid static key to be rejected")
+	}
+}
+
+func TestFetchJWKSLimits(t *testing.T) {
+	large := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(strings.Repeat(" ", int(config.JWKSMaxResponseBytes)+1)))
+	}))
+	defer large.Close()
+	if _, _, err := fetchJWKS(large.URL); err == nil || !strings.Contains(err.Error(), "exceeds") {
+		t.Errorf("Expected response size error, got %v", err)
+	}
+
+	redirect := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://169.254.169.254/latest/meta-data", http.StatusFound)
+	}))
+	defer redirect.Close()
+	if _, _, err := fetchJWKS(redirect.URL); err == nil {
+		t.Error("Expected redirects not to be followed")
+	}
+
+	previousTimeout := config.JWKSFetchTimeout
+	config.JWKSFetchTimeout = 50 * time.Millisecond
+	defer func() { config.JWKSFetchTimeout = previousTimeout }()
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer slow.Close()
+	if _, _, err := fetchJWKS(slow.URL); err == nil {
+		t.Error("Expected slow JWKS request to time out")
+	}
+}
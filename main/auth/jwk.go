@@ -0,0 +1,225 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWKS represents a JSON Web Key Set
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWK represents a JSON Web Key
+type JWK struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Alg string `json:"alg,omitempty"`
+	Use string `json:"use,omitempty"`
+	N   string `json:"n,omitempty"`   // modulus for RSA keys
+	E   string `json:"e,omitempty"`   // exponent for RSA keys
+	Crv string `json:"crv,omitempty"` // curve for EC and OKP keys
+	X   string `json:"x,omitempty"`   // x coordinate for EC keys, public key for OKP keys
+	Y   string `json:"y,omitempty"`   // y coordinate for EC keys
+	// Other fields omitted for brevity
+}
+
+// jwkToPublicKey converts a JWK to an RSA, ECDSA or Ed25519 public key
+func jwkToPublicKey(jwk JWK) (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		return jwkToRSA(jwk)
+	case "EC":
+		return jwkToECDSA(jwk)
+	case "OKP":
+		return jwkToEd25519(jwk)
+	default:
+		return nil, fmt.Errorf("unsupported key type: %s", jwk.Kty)
+	}
+}
+
+// jwkToRSA converts a JWK to an RSA public key
+func jwkToRSA(jwk JWK) (*rsa.PublicKey, error) {
+	if jwk.Kty != "RSA" {
+		return nil, fmt.Errorf("key type is not RSA: %s", jwk.Kty)
+	}
+
+	// Decode modulus
+	nBytes, err := base64.RawURLEncoding.DecodeString(jwk.N)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode modulus: %w", err)
+	}
+	n := new(big.Int).SetBytes(nBytes)
+
+	// Decode exponent
+	eBytes, err := base64.RawURLEncoding.DecodeString(jwk.E)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode exponent: %w", err)
+	}
+
+	var eInt int
+	if len(eBytes) < 8 {
+		// Handle short exponents
+		for i := 0; i < len(eBytes); i++ {
+			eInt = (eInt << 8) | int(eBytes[i])
+		}
+	} else {
+		// This is a fallback, but the exponent is typically small in practice
+		var e big.Int
+		e.SetBytes(eBytes)
+		if !e.IsInt64() {
+			return nil, errors.New("exponent is too large")
+		}
+		eInt = int(e.Int64())
+	}
+
+	return &rsa.PublicKey{
+		N: n,
+		E: eInt,
+	}, nil
+}
+
+// jwkToECDSA converts a JWK to an ECDSA public key on the P-256, P-384 or P-521 curve
+func jwkToECDSA(jwk JWK) (*ecdsa.PublicKey, error) {
+	if jwk.Kty != "EC" {
+		return nil, fmt.Errorf("key type is not EC: %s", jwk.Kty)
+	}
+
+	var curve elliptic.Curve
+	var ecdhCurve ecdh.Curve
+	switch jwk.Crv {
+	case "P-256":
+		curve, ecdhCurve = elliptic.P256(), ecdh.P256()
+	case "P-384":
+		curve, ecdhCurve = elliptic.P384(), ecdh.P384()
+	case "P-521":
+		curve, ecdhCurve = elliptic.P521(), ecdh.P521()
+	default:
+		return nil, fmt.Errorf("unsupported curve: %s", jwk.Crv)
+	}
+
+	xBytes, err := base64.RawURLEncoding.DecodeString(jwk.X)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode x coordinate: %w", err)
+	}
+	yBytes, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode y coordinate: %w", err)
+	}
+	size := (curve.Params().BitSize + 7) / 8
+	if len(xBytes) != size || len(yBytes) != size {
+		return nil, fmt.Errorf("invalid coordinate length for curve %s", jwk.Crv)
+	}
+
+	// Reject points that are not on the curve
+	point := make([]byte, 0, 1+2*size)
+	point = append(point, 4) // uncompressed point
+	point = append(point, xBytes...)
+	point = append(point, yBytes...)
+	if _, err := ecdhCurve.NewPublicKey(point); err != nil {
+		return nil, fmt.Errorf("invalid point for curve %s: %w", jwk.Crv, err)
+	}
+
+	return &ecdsa.PublicKey{
+		Curve: curve,
+		X:     new(big.Int).SetBytes(xBytes),
+		Y:     new(big.Int).SetBytes(yBytes),
+	}, nil
+}
+
+// jwkToEd25519 converts an OKP JWK to an Ed25519 public key
+func jwkToEd25519(jwk JWK) (ed25519.PublicKey, error) {
+	if jwk.Kty != "OKP" {
+		return nil, fmt.Errorf("key type is not OKP: %s", jwk.Kty)
+	}
+	if jwk.Crv != "Ed25519" {
+		return nil, fmt.Errorf("unsupported curve: %s", jwk.Crv)
+	}
+
+	xBytes, err := base64.RawURLEncoding.DecodeString(jwk.X)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode public key: %w", err)
+	}
+	if len(xBytes) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid Ed25519 public key length: %d", len(xBytes))
+	}
+	return ed25519.PublicKey(xBytes), nil
+}
+
+// parsePEMPublicKey parses a PEM encoded RSA, ECDSA or Ed25519 public key
+func parsePEMPublicKey(pemBytes []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("failed to decode PEM block")
+	}
+	pubKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+	switch key := pubKey.(type) {
+	case *rsa.PublicKey, ed25519.PublicKey:
+		return key, nil
+	case *ecdsa.PublicKey:
+		if _, err := curveForKey(key); err != nil {
+			return nil, err
+		}
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type: %T", pubKey)
+	}
+}
+
+// curveForKey returns the JWA name of the curve of an ECDSA key
+func curveForKey(key *ecdsa.PublicKey) (string, error) {
+	switch key.Curve {
+	case elliptic.P256():
+		return "P-256", nil
+	case elliptic.P384():
+		return "P-384", nil
+	case elliptic.P521():
+		return "P-521", nil
+	default:
+		return "", fmt.Errorf("unsupported curve: %s", key.Curve.Params().Name)
+	}
+}
+
+// checkSigningMethod checks that the signing method of a token matches the
+// type of the key that verifies it, so that a key is only used with its own
+// algorithm family and, for ECDSA, with the hash size of its curve
+func checkSigningMethod(method jwt.SigningMethod, key crypto.PublicKey) error {
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		switch method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+			return nil
+		}
+	case *ecdsa.PublicKey:
+		curve, err := curveForKey(key)
+		if err != nil {
+			return err
+		}
+		expected := map[string]string{"P-256": "ES256", "P-384": "ES384", "P-521": "ES512"}[curve]
+		if method.Alg() == expected {
+			return nil
+		}
+	case ed25519.PublicKey:
+		if _, ok := method.(*jwt.SigningMethodEd25519); ok {
+			return nil
+		}
+	default:
+		return fmt.Errorf("unsupported public key type: %T", key)
+	}
+	return fmt.Errorf("signing method %s does not match key type %T", method.Alg(), key)
+}
@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"strings"
+	"testing"
+
+	"learnLoop/main/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func newECKey(t *testing.T, curve elliptic.Curve) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate EC key: %v", err)
+	}
+	return key
+}
+
+func newEd25519Key(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate Ed25519 key: %v", err)
+	}
+	return key
+}
+
+func ecJWK(kid string, key *ecdsa.PublicKey) map[string]string {
+	size := (key.Curve.Params().BitSize + 7) / 8
+	return map[string]string{
+		"kid": kid,
+		"kty": "EC",
+		"crv": key.Curve.Params().Name,
+		"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, size))),
+		"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, size))),
+	}
+}
+
+func ed25519JWK(kid string, key ed25519.PublicKey) map[string]string {
+	return map[string]string{
+		"kid": kid,
+		"kty": "OKP",
+		"crv": "Ed25519",
+		"x":   base64.RawURLEncoding.EncodeToString(key),
+	}
+}
+
+func TestValidateJWTKeyTypes(t *testing.T) {
+	rsaKey := newRSAKey(t)
+	p256 := newECKey(t, elliptic.P256())
+	p384 := newECKey(t, elliptic.P384())
+	p521 := newECKey(t, elliptic.P521())
+	edKey := newEd25519Key(t)
+	server, _ := jwksServer(t,
+		rsaJWK("rsa", &rsaKey.PublicKey),
+		ecJWK("p256", &p256.PublicKey),
+		ecJWK("p384", &p384.PublicKey),
+		ecJWK("p521", &p521.PublicKey),
+		ed25519JWK("ed", edKey.Public().(ed25519.PublicKey)),
+	)
+	err := LoadTrustedIssuers([]config.IssuerConfig{
+		{Issuer: "did:web:trusted", JWKSURL: server.URL},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		method  jwt.SigningMethod
+		key     any
+		kid     string
+		wantErr bool
+	}{
+		{"RS256", jwt.SigningMethodRS256, rsaKey, "rsa", false},
+		{"PS256", jwt.SigningMethodPS256, rsaKey, "rsa", false},
+		{"ES256", jwt.SigningMethodES256, p256, "p256", false},
+		{"ES384", jwt.SigningMethodES384, p384, "p384", false},
+		{"ES512", jwt.SigningMethodES512, p521, "p521", false},
+		{"EdDSA", jwt.SigningMethodEdDSA, edKey, "ed", false},
+		{"EdDSA token with EC key", jwt.SigningMethodEdDSA, edKey, "p256", true},
+		{"ES256 token with P-384 key", jwt.SigningMethodES256, p256, "p384", true},
+		{"ES256 token with RSA key", jwt.SigningMethodES256, p256, "rsa", true},
+		{"HS256 token with RSA key", jwt.SigningMethodHS256, []byte("secret"), "rsa", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ValidateJWT(signToken(t, tt.method, tt.key, tt.kid, validClaims("did:web:trusted")))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestValidateJWTStaticECKey(t *testing.T) {
+	key := newECKey(t, elliptic.P256())
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+	pemKey := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	err = LoadTrustedIssuers([]config.IssuerConfig{
+		{Issuer: "static-issuer", PublicKeys: map[string]string{"static1": pemKey}},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := ValidateJWT(signToken(t, jwt.SigningMethodES256, key, "static1", validClaims("static-issuer"))); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+}
+
+func TestJWKToPublicKey(t *testing.T) {
+	p256 := ecJWK("p256", &newECKey(t, elliptic.P256()).PublicKey)
+	offCurve := ecJWK("p256", &newECKey(t, elliptic.P256()).PublicKey)
+	offCurve["y"] = p256["x"]
+	shortEd := ed25519JWK("ed", newEd25519Key(t).Public().(ed25519.PublicKey)[:16])
+
+	tests := []struct {
+		name    string
+		jwk     JWK
+		wantErr string
+	}{
+		{"Valid EC key", JWK{Kty: "EC", Crv: "P-256", X: p256["x"], Y: p256["y"]}, ""},
+		{"Unsupported key type", JWK{Kty: "oct"}, "unsupported key type"},
+		{"Unsupported curve", JWK{Kty: "EC", Crv: "secp256k1", X: p256["x"], Y: p256["y"]}, "unsupported curve"},
+		{"Point not on curve", JWK{Kty: "EC", Crv: "P-256", X: offCurve["x"], Y: offCurve["y"]}, "invalid point"},
+		{"Coordinate of the wrong curve", JWK{Kty: "EC", Crv: "P-384", X: p256["x"], Y: p256["y"]}, "invalid coordinate length"},
+		{"Unsupported OKP curve", JWK{Kty: "OKP", Crv: "X25519", X: shortEd["x"]}, "unsupported curve"},
+		{"Short Ed25519 key", JWK{Kty: "OKP", Crv: "Ed25519", X: shortEd["x"]}, "invalid Ed25519 public key length"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := jwkToPublicKey(tt.jwk)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Expected no error, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package auth
+
+import (
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"learnLoop/main/config"
+)
+
+// jwksCache caches the keys of the JWKS of one issuer, indexed by key ID.
+//
+// Each issuer has its own cache and no lock is held during HTTP requests,
+// so a slow issuer does not block the validation of other issuers' tokens.
+// Concurrent fetches of the same JWKS are shared, keys are refreshed in the
+// background before they expire and an unknown key ID triggers a rate
+// limited refetch, as the issuer may have rotated its keys.
+type jwksCache struct {
+	url string
+
+	mu           sync.Mutex
+	keys         map[string]crypto.PublicKey
+	expiresAt    time.Time
+	fetchedAt    time.Time
+	lastErr      error
+	inflight     *jwksFetch
+	refreshTimer *time.Timer
+	closed       bool
+}
+
+// jwksFetch is a fetch in progress, waited for by every caller needing it
+type jwksFetch struct {
+	done chan struct{}
+	err  error
+}
+
+func newJWKSCache(url string) *jwksCache {
+	return &jwksCache{url: url}
+}
+
+// key returns the key with the given ID, fetching the JWKS if needed.
+// A token without key ID is only accepted if the JWKS has a single key.
+func (c *jwksCache) key(kid string) (crypto.PublicKey, error) {
+	c.mu.Lock()
+	// a fetch in progress may bring the rotated keys, it is waited for even
+	// while refetches are rate limited
+	call := c.inflight
+	rateLimited := call == nil && time.Since(c.fetchedAt) < config.JWKSMinRefetchInterval
+	if c.keys != nil && time.Now().Before(c.expiresAt) {
+		key, err := c.lookup(kid)
+		if err == nil || rateLimited {
+			c.mu.Unlock()
+			return key, err
+		}
+		// unknown key ID, the keys may have been rotated
+	} else if c.lastErr != nil && rateLimited {
+		err := c.lastErr
+		c.mu.Unlock()
+		return nil, err
+	}
+	c.mu.Unlock()
+
+	var err error
+	if call != nil {
+		<-call.done
+		err = call.err
+	} else {
+		err = c.fetch()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lookup(kid)
+}
+
+// lookup returns the cached key with the given ID, never another one.
+// It must be called with the lock held.
+func (c *jwksCache) lookup(kid string) (crypto.PublicKey, error) {
+	if kid == "" {
+		if len(c.keys) == 1 {
+			for _, key := range c.keys {
+				return key, nil
+			}
+		}
+		return nil, fmt.Errorf("token has no key ID and JWKS %s has %d keys", c.url, len(c.keys))
+	}
+	key, ok := c.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("no key with ID %s in JWKS %s", kid, c.url)
+	}
+	return key, nil
+}
+
+// fetch fetches the JWKS, or waits for the fetch already in progress
+func (c *jwksCache) fetch() error {
+	c.mu.Lock()
+	if call := c.inflight; call != nil {
+		c.mu.Unlock()
+		<-call.done
+		return call.err
+	}
+	call := &jwksFetch{done: make(chan struct{})}
+	c.inflight = call
+	c.fetchedAt = time.Now()
+	c.mu.Unlock()
+
+	log.Printf("Fetching JWKS from %s", c.url)
+	keys, ttl, err := loadJWKS(c.url)
+
+	c.mu.Lock()
+	c.inflight = nil
+	c.lastErr = err
+	if err == nil {
+		c.keys = keys
+		c.expiresAt = time.Now().Add(ttl)
+		c.scheduleRefresh(ttl - ttl/4)
+	} else if time.Now().Before(c.expiresAt) {
+		// retry while the current keys are still valid
+		c.scheduleRefresh(config.JWKSMinRefetchInterval)
+	}
+	c.mu.Unlock()
+
+	call.err = err
+	close(call.done)
+	return err
+}
+
+// scheduleRefresh refreshes the keys in the background after delay.
+// It must be called with the lock held.
+func (c *jwksCache) scheduleRefresh(delay time.Duration) {
+	if c.closed {
+		return
+	}
+	if c.refreshTimer != nil {
+		c.refreshTimer.Stop()
+	}
+	c.refreshTimer = time.AfterFunc(delay, func() {
+		if err := c.fetch(); err != nil {
+			log.Printf("Error refreshing JWKS %s: %v", c.url, err)
+		}
+	})
+}
+
+// close stops the background refresh
+func (c *jwksCache) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	if c.refreshTimer != nil {
+		c.refreshTimer.Stop()
+	}
+}
+
+// loadJWKS fetches the JWKS at url and returns its keys indexed by key ID
+// with their lifetime. Keys that cannot be used are skipped.
+func loadJWKS(url string) (map[string]crypto.PublicKey, time.Duration, error) {
+	body, header, err := fetchJWKS(url)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var jwks JWKS
+	if err := json.Unmarshal(body, &jwks); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode JWKS response: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwkToPublicKey(jwk)
+		if err != nil {
+			log.Printf("Warning: skipping key %s of JWKS %s: %v", jwk.Kid, url, err)
+			continue
+		}
+		if _, exists := keys[jwk.Kid]; exists {
+			log.Printf("Warning: skipping duplicate key %s of JWKS %s", jwk.Kid, url)
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, 0, errors.New("no keys found in JWKS")
+	}
+	return keys, cacheTTL(header), nil
+}
+
+// cacheTTL returns the lifetime of a response according to its Cache-Control
+// header, bounded by the configured minimum refetch interval and maximum TTL
+func cacheTTL(header http.Header) time.Duration {
+	ttl := config.JWKSDefaultTTL
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch strings.ToLower(name) {
+		case "no-cache", "no-store":
+			ttl = 0
+		case "max-age":
+			if seconds, err := strconv.Atoi(strings.Trim(value, `"`)); err == nil && ttl != 0 {
+				ttl = time.Duration(seconds) * time.Second
+			}
+		}
+	}
+	return min(max(ttl, config.JWKSMinRefetchInterval), config.JWKSMaxTTL)
+}
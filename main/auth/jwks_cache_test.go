@@ -0,0 +1,267 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"learnLoop/main/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// rotatingJWKSServer serves the keys set with setKeys and counts the requests
+func rotatingJWKSServer(t *testing.T, handler func()) (server *httptest.Server, setKeys func(keys ...any), requests *atomic.Int32) {
+	t.Helper()
+	var mu sync.Mutex
+	var current []any
+	requests = &atomic.Int32{}
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if handler != nil {
+			handler()
+		}
+		mu.Lock()
+		keys := current
+		mu.Unlock()
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+	}))
+	t.Cleanup(server.Close)
+	setKeys = func(keys ...any) {
+		mu.Lock()
+		defer mu.Unlock()
+		current = keys
+	}
+	return server, setKeys, requests
+}
+
+func setMinRefetchInterval(t *testing.T, interval time.Duration) {
+	t.Helper()
+	previous := config.JWKSMinRefetchInterval
+	config.JWKSMinRefetchInterval = interval
+	t.Cleanup(func() { config.JWKSMinRefetchInterval = previous })
+}
+
+func TestCacheTTL(t *testing.T) {
+	tests := []struct {
+		cacheControl string
+		expected     time.Duration
+	}{
+		{"", config.JWKSDefaultTTL},
+		{"public, max-age=600", 10 * time.Minute},
+		{"max-age=\"600\"", 10 * time.Minute},
+		{"max-age=1", config.JWKSMinRefetchInterval},
+		{"max-age=31536000", config.JWKSMaxTTL},
+		{"no-cache", config.JWKSMinRefetchInterval},
+		{"no-store, max-age=600", config.JWKSMinRefetchInterval},
+		{"max-age=invalid", config.JWKSDefaultTTL},
+	}
+	for _, tt := range tests {
+		t.Run(tt.cacheControl, func(t *testing.T) {
+			header := http.Header{}
+			header.Set("Cache-Control", tt.cacheControl)
+			if ttl := cacheTTL(header); ttl != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, ttl)
+			}
+		})
+	}
+}
+
+func TestJWKSCacheKeyRotation(t *testing.T) {
+	setMinRefetchInterval(t, 0)
+	key1, key2, key3 := newRSAKey(t), newRSAKey(t), newRSAKey(t)
+	server, setKeys, requests := rotatingJWKSServer(t, nil)
+	setKeys(rsaJWK("key1", &key1.PublicKey))
+	if err := LoadTrustedIssuers([]config.IssuerConfig{{Issuer: "did:web:trusted", JWKSURL: server.URL}}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if _, err := ValidateJWT(signToken(t, jwt.SigningMethodRS256, key1, "key1", validClaims("did:web:trusted"))); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := ValidateJWT(signToken(t, jwt.SigningMethodRS256, key1, "key1", validClaims("did:web:trusted"))); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if requests.Load() != 1 {
+		t.Fatalf("Expected the JWKS to be fetched once, got %d requests", requests.Load())
+	}
+
+	// the issuer rotates its keys
+	setKeys(rsaJWK("key1", &key1.PublicKey), rsaJWK("key2", &key2.PublicKey))
+	if _, err := ValidateJWT(signToken(t, jwt.SigningMethodRS256, key2, "key2", validClaims("did:web:trusted"))); err != nil {
+		t.Fatalf("Expected unknown key ID to trigger a refetch, got %v", err)
+	}
+	if requests.Load() != 2 {
+		t.Fatalf("Expected 2 requests, got %d", requests.Load())
+	}
+
+	// an unknown key ID never falls back to another key
+	if _, err := ValidateJWT(signToken(t, jwt.SigningMethodRS256, key1, "key3", validClaims("did:web:trusted"))); err == nil {
+		t.Error("Expected unknown key ID to be rejected")
+	}
+
+	// refetches are rate limited
+	config.JWKSMinRefetchInterval = time.Hour
+	setKeys(rsaJWK("key3", &key3.PublicKey))
+	before := requests.Load()
+	for range 3 {
+		if _, err := ValidateJWT(signToken(t, jwt.SigningMethodRS256, key3, "key3", validClaims("did:web:trusted"))); err == nil {
+			t.Error("Expected unknown key ID to be rejected while refetches are rate limited")
+		}
+	}
+	if requests.Load() != before {
+		t.Errorf("Expected no request while rate limited, got %d", requests.Load()-before)
+	}
+}
+
+func TestJWKSCacheKeyRotationInFlight(t *testing.T) {
+	setMinRefetchInterval(t, time.Hour)
+	key1, key2 := newRSAKey(t), newRSAKey(t)
+	release := make(chan struct{})
+	var blocked atomic.Bool
+	server, setKeys, requests := rotatingJWKSServer(t, func() {
+		if blocked.Load() {
+			<-release
+		}
+	})
+	setKeys(rsaJWK("key1", &key1.PublicKey))
+	if err := LoadTrustedIssuers([]config.IssuerConfig{{Issuer: "did:web:trusted", JWKSURL: server.URL}}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := ValidateJWT(signToken(t, jwt.SigningMethodRS256, key1, "key1", validClaims("did:web:trusted"))); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// the issuer rotates its keys while a refresh is in flight
+	setKeys(rsaJWK("key1", &key1.PublicKey), rsaJWK("key2", &key2.PublicKey))
+	blocked.Store(true)
+	cache := trustedIssuers["did:web:trusted"].jwks
+	refreshed := make(chan error, 1)
+	go func() { refreshed <- cache.fetch() }()
+	for requests.Load() != 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := ValidateJWT(signToken(t, jwt.SigningMethodRS256, key2, "key2", validClaims("did:web:trusted")))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("Expected unknown key ID to wait for the fetch in flight, got %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Errorf("Expected the rotated key to be accepted, got %v", err)
+	}
+	if err := <-refreshed; err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	if requests.Load() != 2 {
+		t.Errorf("Expected the validation to share the fetch in flight, got %d requests", requests.Load())
+	}
+}
+
+func TestJWKSCacheSingleflight(t *testing.T) {
+	key := newRSAKey(t)
+	server, setKeys, requests := rotatingJWKSServer(t, func() { time.Sleep(50 * time.Millisecond) })
+	setKeys(rsaJWK("key1", &key.PublicKey))
+	if err := LoadTrustedIssuers([]config.IssuerConfig{{Issuer: "did:web:trusted", JWKSURL: server.URL}}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	token := signToken(t, jwt.SigningMethodRS256, key, "key1", validClaims("did:web:trusted"))
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := ValidateJWT(token); err != nil {
+				t.Errorf("Expected no error, got %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if requests.Load() != 1 {
+		t.Errorf("Expected concurrent validations to share one fetch, got %d requests", requests.Load())
+	}
+}
+
+func TestJWKSCacheSlowIssuer(t *testing.T) {
+	release := make(chan struct{})
+	slowKey, fastKey := newRSAKey(t), newRSAKey(t)
+	slowDone := make(chan struct{})
+	slow, setSlowKeys, _ := rotatingJWKSServer(t, func() { <-release })
+	t.Cleanup(func() {
+		close(release)
+		<-slowDone
+	})
+	setSlowKeys(rsaJWK("slow", &slowKey.PublicKey))
+	fast, setFastKeys, _ := rotatingJWKSServer(t, nil)
+	setFastKeys(rsaJWK("fast", &fastKey.PublicKey))
+	err := LoadTrustedIssuers([]config.IssuerConfig{
+		{Issuer: "did:web:slow", JWKSURL: slow.URL},
+		{Issuer: "did:web:fast", JWKSURL: fast.URL},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	slowToken := signToken(t, jwt.SigningMethodRS256, slowKey, "slow", validClaims("did:web:slow"))
+	go func() {
+		defer close(slowDone)
+		_, _ = ValidateJWT(slowToken)
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	fastToken := signToken(t, jwt.SigningMethodRS256, fastKey, "fast", validClaims("did:web:fast"))
+	done := make(chan error, 1)
+	go func() {
+		_, err := ValidateJWT(fastToken)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected a slow issuer not to block the validation of other issuers")
+	}
+}
+
+func TestJWKSCacheBackgroundRefresh(t *testing.T) {
+	setMinRefetchInterval(t, 40*time.Millisecond)
+	key := newRSAKey(t)
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Cache-Control", "no-cache")
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": []any{rsaJWK("key1", &key.PublicKey)}})
+	}))
+	defer server.Close()
+	cache := newJWKSCache(server.URL)
+
+	if _, err := cache.key("key1"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	cache.mu.Lock()
+	expiresAt := cache.expiresAt
+	cache.mu.Unlock()
+	cache.close()
+
+	if requests.Load() < 3 {
+		t.Errorf("Expected the keys to be refreshed in the background, got %d requests", requests.Load())
+	}
+	if !time.Now().Before(expiresAt) {
+		t.Error("Expected the keys to be refreshed before they expire")
+	}
+}
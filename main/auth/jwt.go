@@ -1,18 +1,14 @@
 package auth
 
 import (
-	"crypto/rsa"
-	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
-	"encoding/pem"
 	"errors"
 	"fmt"
 	"log"
-	"math/big"
 	"net/http"
+	"slices"
 	"strings"
-	"sync"
 	"time"
 
 	"learnLoop/main/config"
@@ -20,18 +16,6 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var (
-	publicKeyCache map[string][]byte // Cache keys by URL
-	publicKeyMux   sync.RWMutex
-	keyTTLMap      map[string]time.Time // Track fetch time for each URL
-	keyTTL         = 12 * time.Hour
-)
-
-func init() {
-	publicKeyCache = make(map[string][]byte)
-	keyTTLMap = make(map[string]time.Time)
-}
-
 // Claims represents the JWT claims structure
 type Claims struct {
 	jwt.RegisteredClaims
@@ -39,25 +23,84 @@ type Claims struct {
 	InstanceName string `json:"instanceBaseName"`
 }
 
+// Identity is the identity of an authenticated user, extracted from verified claims
+type Identity struct {
+	// Issuer is the trusted issuer of the token
+	Issuer       string
+	UserID       string
+	Login        string
+	DisplayName  string
+	InstanceName string
+	// TokenID is the jti claim of the token, empty if missing
+	TokenID string
+	// Roles are the values of the configured role claim
+	Roles []string
+	// ExpiresAt is the expiry of the token, zero if the token does not expire
+	ExpiresAt time.Time
+	// Claims are the verified claims of the token
+	Claims map[string]any
+}
+
 // extractClaimsWithoutVerification extracts claims from a token without verifying the signature
 func extractClaimsWithoutVerification(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	if err := decodeClaims(tokenString, claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// decodeClaims decodes the claims segment of a token into claims
+func decodeClaims(tokenString string, claims any) error {
 	parts := strings.Split(tokenString, ".")
 	if len(parts) != 3 {
-		return nil, errors.New("token contains an invalid number of segments")
+		return errors.New("token contains an invalid number of segments")
 	}
 
 	// Decode the claims part (second segment)
 	claimBytes, err := base64.RawURLEncoding.DecodeString(parts[1])
 	if err != nil {
-		return nil, fmt.Errorf("error decoding the token's claims: %w", err)
+		return fmt.Errorf("error decoding the token's claims: %w", err)
 	}
 
-	claims := &Claims{}
 	if err := json.Unmarshal(claimBytes, claims); err != nil {
-		return nil, fmt.Errorf("error unmarshaling the token's claims: %w", err)
+		return fmt.Errorf("error unmarshaling the token's claims: %w", err)
 	}
 
-	return claims, nil
+	return nil
+}
+
+// extractRoles returns the values of the claim named claimName,
+// which can either be a string or an array of strings
+func extractRoles(claims map[string]any, claimName string) ([]string, error) {
+	switch value := claims[claimName].(type) {
+	case nil:
+		return []string{}, nil
+	case string:
+		return strings.Fields(value), nil
+	case []any:
+		roles := make([]string, 0, len(value))
+		for _, v := range value {
+			role, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("claim %s contains a non string value: %v", claimName, v)
+			}
+			roles = append(roles, role)
+		}
+		return roles, nil
+	default:
+		return nil, fmt.Errorf("claim %s has an unexpected type: %T", claimName, value)
+	}
+}
+
+// extractString returns the value of the string claim named claimName,
+// defaultValue if the claim is missing, empty or not a string
+func extractString(claims map[string]any, claimName string, defaultValue string) string {
+	value, ok := claims[claimName].(string)
+	if !ok || strings.TrimSpace(value) == "" {
+		return defaultValue
+	}
+	return strings.TrimSpace(value)
 }
 
 // buildPublicKeyURL builds the public key URL based on the issuer claim
@@ -82,20 +125,6 @@ func buildPublicKeyURL(issuer string) (string, error) {
 	return fmt.Sprintf("https://%s%s/.well-known/jwks.json", domain, port), nil
 }
 
-// JWKS represents a JSON Web Key Set
-type JWKS struct {
-	Keys []JWK `json:"keys"`
-}
-
-// JWK represents a JSON Web Key
-type JWK struct {
-	Kid string `json:"kid"`
-	Kty string `json:"kty"`
-	N   string `json:"n"` // modulus for RSA keys
-	E   string `json:"e"` // exponent for RSA keys
-	// Other fields omitted for brevity
-}
-
 // extractJWTHeader extracts and decodes the header from a JWT token
 func extractJWTHeader(tokenString string) (map[string]interface{}, error) {
 	parts := strings.Split(tokenString, ".")
@@ -129,55 +158,89 @@ func extractUserIDFromSubject(subject string) (string, error) {
 	return "", fmt.Errorf("invalid subject format: %s", subject)
 }
 
-// ValidateJWT validates the JWT token and returns user ID and instance name if valid
-func ValidateJWT(tokenString string) (string, string, error) {
+// BearerToken returns the token of the Authorization: Bearer header of the
+// request, empty if missing
+func BearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// ValidateJWT validates the JWT token and returns the identity of the user if valid.
+// Rejected tokens are logged and counted by RejectionReason.
+func ValidateJWT(tokenString string) (*Identity, error) {
+	identity, err := validateJWT(tokenString, time.Now())
+	if err != nil {
+		recordRejection(err)
+		return nil, err
+	}
+	return identity, nil
+}
+
+func validateJWT(tokenString string, now time.Time) (*Identity, error) {
 	// Extract claims without verification to get the issuer
 	claims, err := extractClaimsWithoutVerification(tokenString)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to extract token claims: %w", err)
+		return nil, reject(REJECTION_REASON_MALFORMED, fmt.Errorf("failed to extract token claims: %w", err))
 	}
 
 	// Extract header to get the kid
 	header, err := extractJWTHeader(tokenString)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to extract token header: %w", err)
+		return nil, reject(REJECTION_REASON_MALFORMED, fmt.Errorf("failed to extract token header: %w", err))
 	}
 
 	// Get the key ID
 	kid, _ := header["kid"].(string)
 
 	if claims.Issuer == "" {
-		return "", "", fmt.Errorf("failed to build public key URL")
+		return nil, reject(REJECTION_REASON_UNTRUSTED_ISSUER, errors.New("token has no issuer"))
 	}
-	// Try to build URL from issuer
-	pubKeyURL, err := buildPublicKeyURL(claims.Issuer)
+	// Only trusted issuers are accepted, before any network call
+	issuer, err := findTrustedIssuer(claims.Issuer)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to build public key URL from issuer %s : %v", claims.Issuer, err)
+		return nil, reject(REJECTION_REASON_UNTRUSTED_ISSUER, err)
+	}
+
+	policy := getValidationPolicy()
+	alg, _ := header["alg"].(string)
+	if !slices.Contains(policy.algorithms, alg) {
+		return nil, reject(REJECTION_REASON_ALGORITHM_NOT_ALLOWED, fmt.Errorf("algorithm %s is not allowed", alg))
 	}
-	fmt.Println("pubKeyURL: ", pubKeyURL)
 
-	// Get public key for this URL and key ID
-	pubKey, err := getPublicKey(pubKeyURL, kid)
+	// Get public key of this issuer for this key ID
+	pubKey, err := issuer.publicKey(kid)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to get public key: %w", err)
+		return nil, reject(REJECTION_REASON_UNKNOWN_KEY, fmt.Errorf("failed to get public key: %w", err))
 	}
 
 	// Parse the token with verification
+	options := append(policy.parserOptions(), jwt.WithTimeFunc(func() time.Time { return now }))
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (any, error) {
-		// Check that the signing method is what we expect
-		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		// Check that the signing method matches the type of the key
+		if err := checkSigningMethod(token.Method, pubKey); err != nil {
+			return nil, err
 		}
 
-		// Return the RSA public key for verification
 		return pubKey, nil
-	})
+	}, options...)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to parse or verify token: %w", err)
+		return nil, reject(parseErrorReason(err), fmt.Errorf("failed to parse or verify token: %w", err))
 	}
 
 	// Validate the token
 	if verifiedClaims, ok := token.Claims.(*Claims); ok && token.Valid {
+		// the signature is verified, the other claims can be trusted
+		var rawClaims map[string]any
+		if err := decodeClaims(tokenString, &rawClaims); err != nil {
+			return nil, reject(REJECTION_REASON_MALFORMED, err)
+		}
+		if err := policy.check(verifiedClaims, rawClaims, now); err != nil {
+			return nil, err
+		}
+
 		// Extract user ID from subject if it's in the DID format
 		var userID string
 		if strings.HasPrefix(verifiedClaims.Sub, "did:") {
@@ -192,182 +255,34 @@ func ValidateJWT(tokenString string) (string, string, error) {
 			userID = verifiedClaims.Sub
 		}
 
-		return userID, verifiedClaims.InstanceName, nil
-	}
-
-	return "", "", errors.New("invalid token")
-}
-
-// getPublicKey retrieves the public key from the specified URL or from cache
-func getPublicKey(url string, kid string) (*rsa.PublicKey, error) {
-	publicKeyMux.RLock()
-	keyName := url
-	if kid != "" {
-		keyName = url + "#" + kid
-	}
-
-	// Check if we have this key in cache
-	if pemBytes, exists := publicKeyCache[keyName]; exists {
-		fetchTime := keyTTLMap[keyName]
-		if time.Since(fetchTime) < keyTTL {
-			defer publicKeyMux.RUnlock()
-
-			// Parse the cached PEM key
-			block, _ := pem.Decode(pemBytes)
-			if block == nil {
-				return nil, errors.New("failed to decode PEM block from cache")
-			}
-
-			// Parse the public key
-			pubKey, err := x509.ParsePKIXPublicKey(block.Bytes)
-			if err != nil {
-				return nil, fmt.Errorf("failed to parse public key from cache: %w", err)
-			}
-
-			// Convert to RSA public key
-			rsaKey, ok := pubKey.(*rsa.PublicKey)
-			if !ok {
-				return nil, errors.New("cached key is not an RSA public key")
-			}
-
-			return rsaKey, nil
+		roles, err := extractRoles(rawClaims, config.RoleClaim)
+		if err != nil {
+			return nil, reject(REJECTION_REASON_INVALID_CLAIM, fmt.Errorf("failed to extract roles: %w", err))
 		}
-	}
-	publicKeyMux.RUnlock()
-
-	// Need to fetch new key
-	publicKeyMux.Lock()
-	defer publicKeyMux.Unlock()
-
-	// Double check in case another goroutine fetched the key
-	if pemBytes, exists := publicKeyCache[keyName]; exists {
-		fetchTime := keyTTLMap[keyName]
-		if time.Since(fetchTime) < keyTTL {
-			// Parse the cached PEM key
-			block, _ := pem.Decode(pemBytes)
-			if block != nil {
-				pubKey, err := x509.ParsePKIXPublicKey(block.Bytes)
-				if err == nil {
-					rsaKey, ok := pubKey.(*rsa.PublicKey)
-					if ok {
-						return rsaKey, nil
-					}
-				}
-			}
+		if len(roles) == 0 {
+			roles = []string{config.DefaultRole}
 		}
-	}
-
-	// Fetch from the specified URL
-	log.Printf("Fetching JWKS from %s", url)
-	resp, err := http.Get(url)
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to fetch JWKS, status code: %d", resp.StatusCode)
-	}
-
-	// Parse the JWKS response
-	var jwks JWKS
-	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
-		return nil, fmt.Errorf("failed to decode JWKS response: %w", err)
-	}
-
-	if len(jwks.Keys) == 0 {
-		return nil, errors.New("no keys found in JWKS")
-	}
-
-	// Find the key with matching kid or use the first one
-	var selectedKey *JWK
-	if kid != "" {
-		for _, key := range jwks.Keys {
-			if key.Kid == kid {
-				selectedKey = &key
-				break
-			}
+		login := extractString(rawClaims, config.LoginClaim, userID)
+		var expiresAt time.Time
+		if verifiedClaims.ExpiresAt != nil {
+			expiresAt = verifiedClaims.ExpiresAt.Time
 		}
-		if selectedKey == nil {
-			log.Printf("Warning: No key with ID %s found, using first key", kid)
-			selectedKey = &jwks.Keys[0]
+		identity := &Identity{
+			Issuer:       verifiedClaims.Issuer,
+			UserID:       userID,
+			TokenID:      verifiedClaims.ID,
+			Login:        login,
+			DisplayName:  extractString(rawClaims, config.NameClaim, login),
+			InstanceName: verifiedClaims.InstanceName,
+			Roles:        roles,
+			ExpiresAt:    expiresAt,
+			Claims:       rawClaims,
 		}
-	} else {
-		selectedKey = &jwks.Keys[0]
-		log.Println("Using the first key in JWKS:", selectedKey.Kid)
-	}
-
-	// Convert JWK to RSA public key
-	rsaKey, err := jwkToRSA(*selectedKey)
-	if err != nil {
-		return nil, fmt.Errorf("failed to convert JWK to RSA key: %w", err)
-	}
-
-	// Cache the key in PEM format
-	pemKey, err := rsaToPEM(rsaKey)
-	if err != nil {
-		return nil, fmt.Errorf("failed to convert RSA key to PEM: %w", err)
-	}
-
-	publicKeyCache[keyName] = pemKey
-	keyTTLMap[keyName] = time.Now()
-	return rsaKey, nil
-}
-
-// jwkToRSA converts a JWK to an RSA public key
-func jwkToRSA(jwk JWK) (*rsa.PublicKey, error) {
-	if jwk.Kty != "RSA" {
-		return nil, fmt.Errorf("key type is not RSA: %s", jwk.Kty)
-	}
-
-	// Decode modulus
-	nBytes, err := base64.RawURLEncoding.DecodeString(jwk.N)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode modulus: %w", err)
-	}
-	n := new(big.Int).SetBytes(nBytes)
-
-	// Decode exponent
-	eBytes, err := base64.RawURLEncoding.DecodeString(jwk.E)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode exponent: %w", err)
-	}
-
-	var eInt int
-	if len(eBytes) < 8 {
-		// Handle short exponents
-		for i := 0; i < len(eBytes); i++ {
-			eInt = (eInt << 8) | int(eBytes[i])
+		if err := CheckRevocation(identity); err != nil {
+			return nil, err
 		}
-	} else {
-		// This is a fallback, but the exponent is typically small in practice
-		var e big.Int
-		e.SetBytes(eBytes)
-		if !e.IsInt64() {
-			return nil, errors.New("exponent is too large")
-		}
-		eInt = int(e.Int64())
-	}
-
-	return &rsa.PublicKey{
-		N: n,
-		E: eInt,
-	}, nil
-}
-
-// rsaToPEM converts an RSA public key to PEM format for caching
-func rsaToPEM(key *rsa.PublicKey) ([]byte, error) {
-	// Marshal the public key to DER format
-	derBytes, err := x509.MarshalPKIXPublicKey(key)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal public key: %w", err)
-	}
-
-	// Encode to PEM format
-	pemBlock := &pem.Block{
-		Type:  "PUBLIC KEY",
-		Bytes: derBytes,
+		return identity, nil
 	}
 
-	return pem.EncodeToMemory(pemBlock), nil
+	return nil, reject(REJECTION_REASON_MALFORMED, errors.New("invalid token"))
 }
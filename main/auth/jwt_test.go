@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"encoding/base64"
+	"reflect"
+	"testing"
+)
+
+// unsignedToken builds a token with the given claims and no valid signature
+func unsignedToken(claims string) string {
+	encode := base64.RawURLEncoding.EncodeToString
+	return encode([]byte(`{"alg":"none"}`)) + "." + encode([]byte(claims)) + "."
+}
+
+func TestExtractRoles(t *testing.T) {
+	tests := []struct {
+		name      string
+		claims    string
+		expected  []string
+		expectErr bool
+	}{
+		{"missing claim", `{"sub":"user"}`, []string{}, false},
+		{"string claim", `{"role":"facilitator"}`, []string{"facilitator"}, false},
+		{"space separated claim", `{"role":"learner quiz:control"}`, []string{"learner", "quiz:control"}, false},
+		{"array claim", `{"role":["learner","facilitator"]}`, []string{"learner", "facilitator"}, false},
+		{"invalid array claim", `{"role":["learner",1]}`, nil, true},
+		{"invalid claim type", `{"role":1}`, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var claims map[string]any
+			if err := decodeClaims(unsignedToken(tt.claims), &claims); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			roles, err := extractRoles(claims, "role")
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("Expected error %v, got %v", tt.expectErr, err)
+			}
+			if !tt.expectErr && !reflect.DeepEqual(roles, tt.expected) {
+				t.Errorf("Expected roles %v, got %v", tt.expected, roles)
+			}
+		})
+	}
+}
+
+func TestExtractString(t *testing.T) {
+	claims := map[string]any{
+		"preferred_username": " jdoe ",
+		"name":               "",
+		"age":                42,
+	}
+	tests := []struct {
+		claimName string
+		expected  string
+	}{
+		{"preferred_username", "jdoe"},
+		{"name", "default"},
+		{"age", "default"},
+		{"missing", "default"},
+	}
+	for _, tt := range tests {
+		if value := extractString(claims, tt.claimName, "default"); value != tt.expected {
+			t.Errorf("Expected %s for claim %s, got %s", tt.expected, tt.claimName, value)
+		}
+	}
+}
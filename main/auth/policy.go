@@ -0,0 +1,205 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"learnLoop/main/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// RejectionReason is the reason why a token is rejected
+type RejectionReason string
+
+const (
+	REJECTION_REASON_MALFORMED             RejectionReason = "malformed"
+	REJECTION_REASON_UNTRUSTED_ISSUER      RejectionReason = "untrusted_issuer"
+	REJECTION_REASON_ALGORITHM_NOT_ALLOWED RejectionReason = "algorithm_not_allowed"
+	REJECTION_REASON_UNKNOWN_KEY           RejectionReason = "unknown_key"
+	REJECTION_REASON_KEY_MISMATCH          RejectionReason = "key_mismatch"
+	REJECTION_REASON_INVALID_SIGNATURE     RejectionReason = "invalid_signature"
+	REJECTION_REASON_EXPIRED               RejectionReason = "expired"
+	REJECTION_REASON_NOT_YET_VALID         RejectionReason = "not_yet_valid"
+	REJECTION_REASON_TOO_OLD               RejectionReason = "too_old"
+	REJECTION_REASON_INVALID_AUDIENCE      RejectionReason = "invalid_audience"
+	REJECTION_REASON_MISSING_CLAIM         RejectionReason = "missing_claim"
+	REJECTION_REASON_INVALID_CLAIM         RejectionReason = "invalid_claim"
+	REJECTION_REASON_REVOKED               RejectionReason = "revoked"
+)
+
+// RejectionError is the error of a rejected token
+type RejectionError struct {
+	Reason RejectionReason
+	Err    error
+}
+
+func (e *RejectionError) Error() string {
+	return fmt.Sprintf("token rejected (%s): %v", e.Reason, e.Err)
+}
+
+func (e *RejectionError) Unwrap() error {
+	return e.Err
+}
+
+func reject(reason RejectionReason, err error) error {
+	return &RejectionError{Reason: reason, Err: err}
+}
+
+// RejectionReasonOf returns the reason of a token rejection error
+func RejectionReasonOf(err error) RejectionReason {
+	var rejectionErr *RejectionError
+	if errors.As(err, &rejectionErr) {
+		return rejectionErr.Reason
+	}
+	return REJECTION_REASON_MALFORMED
+}
+
+// validationPolicy is the loaded config.ValidationPolicy
+type validationPolicy struct {
+	audiences      []string
+	algorithms     []string
+	leeway         time.Duration
+	requiredClaims []string
+	maxTokenAge    time.Duration
+}
+
+var (
+	currentPolicy    = newValidationPolicy(config.DefaultValidationPolicy())
+	currentPolicyMux sync.RWMutex
+
+	rejectionCounts    = make(map[RejectionReason]int64)
+	rejectionCountsMux sync.Mutex
+)
+
+func newValidationPolicy(policy config.ValidationPolicy) *validationPolicy {
+	loaded := &validationPolicy{
+		audiences:      policy.Audiences,
+		algorithms:     policy.Algorithms,
+		requiredClaims: policy.RequiredClaims,
+	}
+	if policy.Leeway != nil {
+		loaded.leeway = time.Duration(*policy.Leeway)
+	}
+	if policy.MaxTokenAge != nil {
+		loaded.maxTokenAge = time.Duration(*policy.MaxTokenAge)
+	}
+	return loaded
+}
+
+// LoadValidationPolicy replaces the validation policy of tokens
+func LoadValidationPolicy(policy config.ValidationPolicy) error {
+	if len(policy.Algorithms) == 0 {
+		return errors.New("no allowed algorithm")
+	}
+	for _, alg := range policy.Algorithms {
+		if method := jwt.GetSigningMethod(alg); method == nil || method == jwt.SigningMethodNone {
+			return fmt.Errorf("unsupported algorithm: %s", alg)
+		}
+		if _, ok := jwt.GetSigningMethod(alg).(*jwt.SigningMethodHMAC); ok {
+			return fmt.Errorf("symmetric algorithm %s is not allowed", alg)
+		}
+	}
+	loaded := newValidationPolicy(policy)
+	if loaded.leeway < 0 || loaded.maxTokenAge < 0 {
+		return errors.New("leeway and maximum token age cannot be negative")
+	}
+	if len(loaded.audiences) == 0 {
+		log.Println("Warning: no expected audience, tokens of any audience are accepted")
+	}
+
+	currentPolicyMux.Lock()
+	defer currentPolicyMux.Unlock()
+	currentPolicy = loaded
+	return nil
+}
+
+func getValidationPolicy() *validationPolicy {
+	currentPolicyMux.RLock()
+	defer currentPolicyMux.RUnlock()
+	return currentPolicy
+}
+
+// parserOptions are the options of the jwt parser enforcing the policy
+func (policy *validationPolicy) parserOptions() []jwt.ParserOption {
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(policy.algorithms),
+		jwt.WithLeeway(policy.leeway),
+		jwt.WithIssuedAt(),
+	}
+	if slices.Contains(policy.requiredClaims, "exp") {
+		options = append(options, jwt.WithExpirationRequired())
+	}
+	return options
+}
+
+// check checks the verified claims of a token against the policy
+func (policy *validationPolicy) check(claims *Claims, rawClaims map[string]any, now time.Time) error {
+	for _, name := range policy.requiredClaims {
+		if value, ok := rawClaims[name]; !ok || value == nil || value == "" {
+			return reject(REJECTION_REASON_MISSING_CLAIM, fmt.Errorf("claim %s is required", name))
+		}
+	}
+
+	if len(policy.audiences) > 0 {
+		accepted := slices.ContainsFunc(claims.Audience, func(audience string) bool {
+			return slices.Contains(policy.audiences, audience)
+		})
+		if !accepted {
+			return reject(REJECTION_REASON_INVALID_AUDIENCE, fmt.Errorf("audience %v is not expected", claims.Audience))
+		}
+	}
+
+	if policy.maxTokenAge > 0 {
+		if claims.IssuedAt == nil {
+			return reject(REJECTION_REASON_MISSING_CLAIM, errors.New("claim iat is required to check the token age"))
+		}
+		if age := now.Sub(claims.IssuedAt.Time); age > policy.maxTokenAge+policy.leeway {
+			return reject(REJECTION_REASON_TOO_OLD, fmt.Errorf("token was issued %v ago", age.Round(time.Second)))
+		}
+	}
+	return nil
+}
+
+// parseErrorReason returns the rejection reason of an error of the jwt parser
+func parseErrorReason(err error) RejectionReason {
+	switch {
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return REJECTION_REASON_EXPIRED
+	case errors.Is(err, jwt.ErrTokenNotValidYet), errors.Is(err, jwt.ErrTokenUsedBeforeIssued):
+		return REJECTION_REASON_NOT_YET_VALID
+	case errors.Is(err, jwt.ErrTokenRequiredClaimMissing):
+		return REJECTION_REASON_MISSING_CLAIM
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid):
+		return REJECTION_REASON_INVALID_SIGNATURE
+	case errors.Is(err, jwt.ErrTokenUnverifiable):
+		return REJECTION_REASON_KEY_MISMATCH
+	default:
+		return REJECTION_REASON_MALFORMED
+	}
+}
+
+// recordRejection logs and counts a rejected token
+func recordRejection(err error) {
+	reason := RejectionReasonOf(err)
+	log.Printf("Token rejected: %s: %v", reason, err)
+
+	rejectionCountsMux.Lock()
+	defer rejectionCountsMux.Unlock()
+	rejectionCounts[reason]++
+}
+
+// RejectionCounts returns the number of rejected tokens by reason
+func RejectionCounts() map[RejectionReason]int64 {
+	rejectionCountsMux.Lock()
+	defer rejectionCountsMux.Unlock()
+	counts := make(map[RejectionReason]int64, len(rejectionCounts))
+	for reason, count := range rejectionCounts {
+		counts[reason] = count
+	}
+	return counts
+}
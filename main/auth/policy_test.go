@@ -0,0 +1,181 @@
+package auth
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"learnLoop/main/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func loadPolicy(t *testing.T, update func(policy *config.ValidationPolicy)) {
+	t.Helper()
+	policy := config.DefaultValidationPolicy()
+	update(&policy)
+	if err := LoadValidationPolicy(policy); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	t.Cleanup(func() {
+		_ = LoadValidationPolicy(config.DefaultValidationPolicy())
+	})
+}
+
+func duration(d time.Duration) *config.Duration {
+	value := config.Duration(d)
+	return &value
+}
+
+func TestValidateJWTPolicy(t *testing.T) {
+	key := newRSAKey(t)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+	pemKey := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	err = LoadTrustedIssuers([]config.IssuerConfig{
+		{Issuer: "static-issuer", PublicKeys: map[string]string{"key1": pemKey}},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	now := time.Now()
+	tests := []struct {
+		name   string
+		policy func(policy *config.ValidationPolicy)
+		claims func(claims jwt.MapClaims)
+		reason RejectionReason
+	}{
+		{
+			name:   "Default policy",
+			policy: func(policy *config.ValidationPolicy) {},
+			claims: func(claims jwt.MapClaims) {},
+		},
+		{
+			name:   "Expected audience",
+			policy: func(policy *config.ValidationPolicy) { policy.Audiences = []string{"learnloop"} },
+			claims: func(claims jwt.MapClaims) { claims["aud"] = []string{"other", "learnloop"} },
+		},
+		{
+			name:   "Token of another service",
+			policy: func(policy *config.ValidationPolicy) { policy.Audiences = []string{"learnloop"} },
+			claims: func(claims jwt.MapClaims) { claims["aud"] = "billing" },
+			reason: REJECTION_REASON_INVALID_AUDIENCE,
+		},
+		{
+			name:   "Token without audience",
+			policy: func(policy *config.ValidationPolicy) { policy.Audiences = []string{"learnloop"} },
+			claims: func(claims jwt.MapClaims) {},
+			reason: REJECTION_REASON_INVALID_AUDIENCE,
+		},
+		{
+			name:   "Algorithm not allowed",
+			policy: func(policy *config.ValidationPolicy) { policy.Algorithms = []string{"ES256"} },
+			claims: func(claims jwt.MapClaims) {},
+			reason: REJECTION_REASON_ALGORITHM_NOT_ALLOWED,
+		},
+		{
+			name:   "Expired within leeway",
+			policy: func(policy *config.ValidationPolicy) { policy.Leeway = duration(time.Minute) },
+			claims: func(claims jwt.MapClaims) { claims["exp"] = now.Add(-30 * time.Second).Unix() },
+		},
+		{
+			name:   "Expired beyond leeway",
+			policy: func(policy *config.ValidationPolicy) { policy.Leeway = duration(10 * time.Second) },
+			claims: func(claims jwt.MapClaims) { claims["exp"] = now.Add(-30 * time.Second).Unix() },
+			reason: REJECTION_REASON_EXPIRED,
+		},
+		{
+			name:   "Not yet valid",
+			policy: func(policy *config.ValidationPolicy) {},
+			claims: func(claims jwt.MapClaims) { claims["nbf"] = now.Add(time.Hour).Unix() },
+			reason: REJECTION_REASON_NOT_YET_VALID,
+		},
+		{
+			name:   "Missing instance",
+			policy: func(policy *config.ValidationPolicy) {},
+			claims: func(claims jwt.MapClaims) { delete(claims, "instanceBaseName") },
+			reason: REJECTION_REASON_MISSING_CLAIM,
+		},
+		{
+			name:   "Missing expiry",
+			policy: func(policy *config.ValidationPolicy) {},
+			claims: func(claims jwt.MapClaims) { delete(claims, "exp") },
+			reason: REJECTION_REASON_MISSING_CLAIM,
+		},
+		{
+			name:   "Custom required claim",
+			policy: func(policy *config.ValidationPolicy) { policy.RequiredClaims = []string{"jti"} },
+			claims: func(claims jwt.MapClaims) {},
+			reason: REJECTION_REASON_MISSING_CLAIM,
+		},
+		{
+			name:   "Recent token",
+			policy: func(policy *config.ValidationPolicy) { policy.MaxTokenAge = duration(time.Hour) },
+			claims: func(claims jwt.MapClaims) { claims["iat"] = now.Add(-time.Minute).Unix() },
+		},
+		{
+			name:   "Token too old",
+			policy: func(policy *config.ValidationPolicy) { policy.MaxTokenAge = duration(time.Hour) },
+			claims: func(claims jwt.MapClaims) { claims["iat"] = now.Add(-2 * time.Hour).Unix() },
+			reason: REJECTION_REASON_TOO_OLD,
+		},
+		{
+			name:   "Token age without iat",
+			policy: func(policy *config.ValidationPolicy) { policy.MaxTokenAge = duration(time.Hour) },
+			claims: func(claims jwt.MapClaims) {},
+			reason: REJECTION_REASON_MISSING_CLAIM,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loadPolicy(t, tt.policy)
+			claims := validClaims("static-issuer")
+			tt.claims(claims)
+			before := RejectionCounts()[tt.reason]
+
+			_, err := ValidateJWT(signToken(t, jwt.SigningMethodRS256, key, "key1", claims))
+			if tt.reason == "" {
+				if err != nil {
+					t.Errorf("Expected no error, got %v", err)
+				}
+				return
+			}
+			if reason := RejectionReasonOf(err); err == nil || reason != tt.reason {
+				t.Errorf("Expected rejection %s, got %v", tt.reason, err)
+			}
+			if RejectionCounts()[tt.reason] != before+1 {
+				t.Errorf("Expected rejection %s to be counted", tt.reason)
+			}
+		})
+	}
+}
+
+func TestLoadValidationPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  func(policy *config.ValidationPolicy)
+		wantErr bool
+	}{
+		{"Default policy", func(policy *config.ValidationPolicy) {}, false},
+		{"No algorithm", func(policy *config.ValidationPolicy) { policy.Algorithms = []string{} }, true},
+		{"Unknown algorithm", func(policy *config.ValidationPolicy) { policy.Algorithms = []string{"XS256"} }, true},
+		{"Symmetric algorithm", func(policy *config.ValidationPolicy) { policy.Algorithms = []string{"HS256"} }, true},
+		{"None algorithm", func(policy *config.ValidationPolicy) { policy.Algorithms = []string{"none"} }, true},
+		{"Negative leeway", func(policy *config.ValidationPolicy) { policy.Leeway = duration(-time.Second) }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := config.DefaultValidationPolicy()
+			tt.policy(&policy)
+			err := LoadValidationPolicy(policy)
+			t.Cleanup(func() { _ = LoadValidationPolicy(config.DefaultValidationPolicy()) })
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,249 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// RevocationKind is the kind of value a revocation is keyed by
+type RevocationKind string
+
+const (
+	// REVOCATION_KIND_TOKEN revokes the token with the given jti
+	REVOCATION_KIND_TOKEN RevocationKind = "jti"
+	// REVOCATION_KIND_USER bans the user with the given user ID from the
+	// instance of the revocation
+	REVOCATION_KIND_USER RevocationKind = "user"
+)
+
+// Revocation is an entry of the revocation store
+type Revocation struct {
+	Kind  RevocationKind `json:"kind"`
+	Value string         `json:"value"`
+	// InstanceName is the instance the revocation applies to. It is required
+	// to ban a user, user IDs being unique within an instance only, and
+	// optional to revoke a token, the revocation then applying to every
+	// instance.
+	InstanceName string    `json:"instanceName,omitempty"`
+	Reason       string    `json:"reason,omitempty"`
+	RevokedAt    time.Time `json:"revokedAt"`
+	// ExpiresAt is the time the entry is forgotten, never if zero. It is
+	// usually the expiry of a revoked token.
+	ExpiresAt time.Time `json:"expiresAt,omitempty"`
+}
+
+// ErrInvalidRevocation is returned when a revocation has an unknown kind or
+// no value
+var ErrInvalidRevocation = errors.New("invalid revocation")
+
+type revocationKey struct {
+	kind         RevocationKind
+	instanceName string
+	value        string
+}
+
+func (entry Revocation) key() revocationKey {
+	return revocationKey{entry.Kind, entry.InstanceName, entry.Value}
+}
+
+// RevocationStore keeps the revoked tokens and banned users, persisted in a
+// JSON file if its path is set
+type RevocationStore struct {
+	mu        sync.RWMutex
+	path      string
+	entries   map[revocationKey]Revocation
+	listeners []func(Revocation)
+}
+
+// NewRevocationStore creates a store persisted in the file at path, loading
+// its entries if the file exists. The store is kept in memory only if path
+// is empty.
+func NewRevocationStore(path string) (*RevocationStore, error) {
+	store := &RevocationStore{path: path, entries: make(map[revocationKey]Revocation)}
+	if path == "" {
+		return store, nil
+	}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading revocation file %s: %w", path, err)
+	}
+	var entries []Revocation
+	if err := json.Unmarshal(content, &entries); err != nil {
+		return nil, fmt.Errorf("error parsing revocation file %s: %w", path, err)
+	}
+	for _, entry := range entries {
+		if entry.Kind == REVOCATION_KIND_USER && entry.InstanceName == "" {
+			log.Printf("Ignoring ban of user %s without instance name in %s", entry.Value, path)
+			continue
+		}
+		store.entries[entry.key()] = entry
+	}
+	return store, nil
+}
+
+// OnRevoke registers a function called with each new entry, after the
+// entry is stored
+func (store *RevocationStore) OnRevoke(listener func(Revocation)) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.listeners = append(store.listeners, listener)
+}
+
+// Revoke adds an entry to the store and persists it. The entry applies even
+// if it could not be persisted.
+func (store *RevocationStore) Revoke(entry Revocation) error {
+	if entry.Kind != REVOCATION_KIND_TOKEN && entry.Kind != REVOCATION_KIND_USER {
+		return fmt.Errorf("%w: unknown kind %q", ErrInvalidRevocation, entry.Kind)
+	}
+	if entry.Value == "" {
+		return fmt.Errorf("%w: value is missing", ErrInvalidRevocation)
+	}
+	if entry.Kind == REVOCATION_KIND_USER && entry.InstanceName == "" {
+		return fmt.Errorf("%w: instance name of the user is missing", ErrInvalidRevocation)
+	}
+	if entry.RevokedAt.IsZero() {
+		entry.RevokedAt = time.Now()
+	}
+
+	store.mu.Lock()
+	store.entries[entry.key()] = entry
+	err := store.save(time.Now())
+	listeners := slices.Clone(store.listeners)
+	store.mu.Unlock()
+	for _, listener := range listeners {
+		listener(entry)
+	}
+	return err
+}
+
+// Unrevoke removes an entry from the store and persists it. It returns false
+// if there is no such entry.
+func (store *RevocationStore) Unrevoke(kind RevocationKind, instanceName string, value string) (bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	key := revocationKey{kind, instanceName, value}
+	if _, ok := store.entries[key]; !ok {
+		return false, nil
+	}
+	delete(store.entries, key)
+	return true, store.save(time.Now())
+}
+
+// List returns the entries of the store that did not expire
+func (store *RevocationStore) List() []Revocation {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	now := time.Now()
+	entries := []Revocation{}
+	for _, entry := range store.entries {
+		if !entry.expired(now) {
+			entries = append(entries, entry)
+		}
+	}
+	slices.SortFunc(entries, func(a, b Revocation) int { return a.RevokedAt.Compare(b.RevokedAt) })
+	return entries
+}
+
+// Revoked returns the entry revoking the token with the given jti or
+// banning the user of the instance, nil if there is none
+func (store *RevocationStore) Revoked(tokenID string, userID string, instanceName string) *Revocation {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	now := time.Now()
+	keys := []revocationKey{
+		{REVOCATION_KIND_TOKEN, "", tokenID},
+		{REVOCATION_KIND_TOKEN, instanceName, tokenID},
+		{REVOCATION_KIND_USER, instanceName, userID},
+	}
+	for _, key := range keys {
+		if key.value == "" {
+			continue
+		}
+		if entry, ok := store.entries[key]; ok && !entry.expired(now) {
+			return &entry
+		}
+	}
+	return nil
+}
+
+// CheckRevocation returns a rejection with the revoked reason if the token
+// or the user of the identity is revoked. It checks identities authenticated
+// before a revocation, like the identities of connect tickets.
+func CheckRevocation(identity *Identity) error {
+	if revocation := getRevocationStore().Revoked(identity.TokenID, identity.UserID, identity.InstanceName); revocation != nil {
+		return reject(
+			REJECTION_REASON_REVOKED,
+			fmt.Errorf("%s %s is revoked: %s", revocation.Kind, revocation.Value, revocation.Reason),
+		)
+	}
+	return nil
+}
+
+func (entry Revocation) expired(now time.Time) bool {
+	return !entry.ExpiresAt.IsZero() && !now.Before(entry.ExpiresAt)
+}
+
+// save forgets the expired entries and writes the others to the file of the
+// store, replacing it atomically. It must be called with the lock held.
+func (store *RevocationStore) save(now time.Time) error {
+	entries := []Revocation{}
+	for key, entry := range store.entries {
+		if entry.expired(now) {
+			delete(store.entries, key)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if store.path == "" {
+		return nil
+	}
+	slices.SortFunc(entries, func(a, b Revocation) int { return a.RevokedAt.Compare(b.RevokedAt) })
+	content, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(filepath.Dir(store.path), filepath.Base(store.path)+".*")
+	if err != nil {
+		return fmt.Errorf("error writing revocation file %s: %w", store.path, err)
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(content); err != nil {
+		file.Close()
+		return fmt.Errorf("error writing revocation file %s: %w", store.path, err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("error writing revocation file %s: %w", store.path, err)
+	}
+	if err := os.Rename(file.Name(), store.path); err != nil {
+		return fmt.Errorf("error writing revocation file %s: %w", store.path, err)
+	}
+	return nil
+}
+
+var (
+	currentRevocations    = &RevocationStore{entries: make(map[revocationKey]Revocation)}
+	currentRevocationsMux sync.RWMutex
+)
+
+// SetRevocationStore replaces the store checked by ValidateJWT
+func SetRevocationStore(store *RevocationStore) {
+	currentRevocationsMux.Lock()
+	defer currentRevocationsMux.Unlock()
+	currentRevocations = store
+}
+
+func getRevocationStore() *RevocationStore {
+	currentRevocationsMux.RLock()
+	defer currentRevocationsMux.RUnlock()
+	return currentRevocations
+}
@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func useRevocationStore(t *testing.T, store *RevocationStore) {
+	t.Helper()
+	SetRevocationStore(store)
+	t.Cleanup(func() { SetRevocationStore(&RevocationStore{entries: make(map[revocationKey]Revocation)}) })
+}
+
+func TestRevocationStorePersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "revocations.json")
+	store, err := NewRevocationStore(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	revoked := []Revocation{}
+	store.OnRevoke(func(revocation Revocation) { revoked = append(revoked, revocation) })
+
+	entries := []Revocation{
+		{Kind: REVOCATION_KIND_USER, Value: "mallory", InstanceName: "dev", Reason: "spam"},
+		{Kind: REVOCATION_KIND_TOKEN, Value: "token1"},
+		{Kind: REVOCATION_KIND_TOKEN, Value: "expired", ExpiresAt: time.Now().Add(-time.Minute)},
+	}
+	for _, entry := range entries {
+		if err := store.Revoke(entry); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+	if len(revoked) != 3 {
+		t.Errorf("Expected listener to be called for each entry, got %d calls", len(revoked))
+	}
+	if err := store.Revoke(Revocation{Kind: "ip", Value: "127.0.0.1"}); err == nil {
+		t.Error("Expected unknown kind to be rejected")
+	}
+	if err := store.Revoke(Revocation{Kind: REVOCATION_KIND_USER}); err == nil {
+		t.Error("Expected missing value to be rejected")
+	}
+	if err := store.Revoke(Revocation{Kind: REVOCATION_KIND_USER, Value: "mallory"}); err == nil {
+		t.Error("Expected ban without instance name to be rejected")
+	}
+
+	reloaded, err := NewRevocationStore(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if entries := reloaded.List(); len(entries) != 2 {
+		t.Fatalf("Expected 2 entries to be persisted, got %+v", entries)
+	}
+	if revocation := reloaded.Revoked("", "mallory", "dev"); revocation == nil || revocation.Reason != "spam" {
+		t.Errorf("Expected user to be banned, got %+v", revocation)
+	}
+	if reloaded.Revoked("", "mallory", "other") != nil {
+		t.Error("Expected user of another instance not to be banned")
+	}
+	if reloaded.Revoked("token1", "alice", "other") == nil {
+		t.Error("Expected token to be revoked on every instance")
+	}
+	if reloaded.Revoked("expired", "alice", "dev") != nil {
+		t.Error("Expected expired entry to be forgotten")
+	}
+
+	removed, err := reloaded.Unrevoke(REVOCATION_KIND_USER, "dev", "mallory")
+	if err != nil || !removed {
+		t.Fatalf("Expected entry to be removed, got %v, %v", removed, err)
+	}
+	if removed, _ := reloaded.Unrevoke(REVOCATION_KIND_USER, "dev", "mallory"); removed {
+		t.Error("Expected missing entry not to be removed")
+	}
+	reloaded, err = NewRevocationStore(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if reloaded.Revoked("", "mallory", "dev") != nil {
+		t.Error("Expected removal to be persisted")
+	}
+}
+
+func TestValidateJWTRevoked(t *testing.T) {
+	_, provider := devServer(t)
+	store, _ := NewRevocationStore("")
+	useRevocationStore(t, store)
+
+	token, err := provider.MintToken(DevTokenRequest{Subject: "alice"})
+	if err != nil {
+		t.Fatalf("Failed to mint token: %v", err)
+	}
+	identity, err := ValidateJWT(token)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if identity.TokenID == "" {
+		t.Fatal("Expected token ID to be extracted")
+	}
+
+	tests := []struct {
+		name       string
+		revocation Revocation
+	}{
+		{"Revoked token", Revocation{Kind: REVOCATION_KIND_TOKEN, Value: identity.TokenID}},
+		{"Banned user", Revocation{Kind: REVOCATION_KIND_USER, Value: "alice", InstanceName: "dev"}},
+		{"Token revoked on its instance", Revocation{Kind: REVOCATION_KIND_TOKEN, Value: identity.TokenID, InstanceName: "dev"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := store.Revoke(tt.revocation); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			defer store.Unrevoke(tt.revocation.Kind, tt.revocation.InstanceName, tt.revocation.Value)
+
+			_, err := ValidateJWT(token)
+			if reason := RejectionReasonOf(err); err == nil || reason != REJECTION_REASON_REVOKED {
+				t.Errorf("Expected rejection %s, got %v", REJECTION_REASON_REVOKED, err)
+			}
+		})
+	}
+
+	// the ban of a user of another instance does not apply
+	if err := store.Revoke(Revocation{Kind: REVOCATION_KIND_USER, Value: "alice", InstanceName: "other"}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := ValidateJWT(token); err != nil {
+		t.Errorf("Expected token to be valid once revocations are removed, got %v", err)
+	}
+}
@@ -0,0 +1,232 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"learnLoop/main/config"
+)
+
+// Types of session access policies
+const (
+	SESSION_POLICY_TYPE_CLAIM = "claim"
+	SESSION_POLICY_TYPE_ACL   = "acl"
+	SESSION_POLICY_TYPE_HTTP  = "http"
+)
+
+// Default timeout of the requests to a session policy endpoint
+const defaultSessionPolicyTimeout = 2 * time.Second
+
+// ErrSessionAccessDenied is returned when a user may not join a session
+var ErrSessionAccessDenied = errors.New("session access denied")
+
+// SessionAccessPolicy decides whether a user may join a session of its
+// instance. It returns an error wrapping ErrSessionAccessDenied if the user
+// may not, any other error if the decision could not be made.
+type SessionAccessPolicy interface {
+	CheckSessionAccess(ctx context.Context, identity *Identity, sessionID string) error
+}
+
+func denySession(identity *Identity, sessionID string) error {
+	return fmt.Errorf(
+		"%w: user %s may not join session %s of instance %s",
+		ErrSessionAccessDenied, identity.UserID, sessionID, identity.InstanceName,
+	)
+}
+
+// allowAllSessions lets any user join any session
+type allowAllSessions struct{}
+
+func (allowAllSessions) CheckSessionAccess(ctx context.Context, identity *Identity, sessionID string) error {
+	return nil
+}
+
+// ClaimSessionPolicy lets users join the sessions listed in a claim of their
+// token, any session if the claim contains "*"
+type ClaimSessionPolicy struct {
+	Claim string
+}
+
+func (policy *ClaimSessionPolicy) CheckSessionAccess(ctx context.Context, identity *Identity, sessionID string) error {
+	sessions, err := extractRoles(identity.Claims, policy.Claim)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrSessionAccessDenied, err)
+	}
+	if !slices.Contains(sessions, sessionID) && !slices.Contains(sessions, "*") {
+		return denySession(identity, sessionID)
+	}
+	return nil
+}
+
+// SessionACLEntry lists the users and roles allowed to join a session
+type SessionACLEntry struct {
+	Users []string `json:"users,omitempty"`
+	Roles []string `json:"roles,omitempty"`
+}
+
+// allows tells whether the entry lists the user or one of its roles
+func (entry SessionACLEntry) allows(identity *Identity) bool {
+	if slices.Contains(entry.Users, identity.UserID) || slices.Contains(entry.Users, "*") {
+		return true
+	}
+	return slices.ContainsFunc(identity.Roles, func(role string) bool {
+		return slices.Contains(entry.Roles, role)
+	})
+}
+
+// ACLSessionPolicy lets users join the sessions whose entry of the access
+// control list, indexed by instance then session ID, lists them. The entry
+// of the "*" session ID applies to all the sessions of its instance.
+type ACLSessionPolicy struct {
+	ACL map[string]map[string]SessionACLEntry
+}
+
+// LoadACLSessionPolicy reads the access control list of a JSON file
+func LoadACLSessionPolicy(path string) (*ACLSessionPolicy, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading session ACL file %s: %w", path, err)
+	}
+	policy := &ACLSessionPolicy{}
+	if err := json.Unmarshal(content, &policy.ACL); err != nil {
+		return nil, fmt.Errorf("error parsing session ACL file %s: %w", path, err)
+	}
+	return policy, nil
+}
+
+func (policy *ACLSessionPolicy) CheckSessionAccess(ctx context.Context, identity *Identity, sessionID string) error {
+	sessions := policy.ACL[identity.InstanceName]
+	for _, key := range []string{sessionID, "*"} {
+		if entry, ok := sessions[key]; ok && entry.allows(identity) {
+			return nil
+		}
+	}
+	return denySession(identity, sessionID)
+}
+
+// SessionAccessRequest is the body of the requests sent to a session policy
+// endpoint
+type SessionAccessRequest struct {
+	UserID       string   `json:"userId"`
+	Login        string   `json:"login"`
+	InstanceName string   `json:"instanceName"`
+	Roles        []string `json:"roles"`
+	SessionID    string   `json:"sessionId"`
+}
+
+// HTTPSessionPolicy asks a local policy endpoint whether users may join a
+// session. The endpoint answers a POST of a SessionAccessRequest with a 2xx
+// status to allow the user, 403 to deny it.
+type HTTPSessionPolicy struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewHTTPSessionPolicy creates a policy calling the endpoint, requests
+// failing after timeout
+func NewHTTPSessionPolicy(endpoint string, timeout time.Duration) (*HTTPSessionPolicy, error) {
+	parsed, err := url.Parse(endpoint)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid session policy URL %q", endpoint)
+	}
+	return &HTTPSessionPolicy{
+		URL: endpoint,
+		Client: &http.Client{
+			Timeout: timeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}, nil
+}
+
+func (policy *HTTPSessionPolicy) CheckSessionAccess(ctx context.Context, identity *Identity, sessionID string) error {
+	body, err := json.Marshal(SessionAccessRequest{
+		UserID:       identity.UserID,
+		Login:        identity.Login,
+		InstanceName: identity.InstanceName,
+		Roles:        identity.Roles,
+		SessionID:    sessionID,
+	})
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, policy.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	resp, err := policy.Client.Do(request)
+	if err != nil {
+		return fmt.Errorf("error calling session policy endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusForbidden:
+		return denySession(identity, sessionID)
+	default:
+		return fmt.Errorf("session policy endpoint answered with status %d", resp.StatusCode)
+	}
+}
+
+var (
+	currentSessionPolicy    SessionAccessPolicy = allowAllSessions{}
+	currentSessionPolicyMux sync.RWMutex
+)
+
+// NewSessionAccessPolicy creates the session access policy of the
+// configuration, letting any user join any session if its type is empty
+func NewSessionAccessPolicy(policy config.SessionPolicy) (SessionAccessPolicy, error) {
+	switch policy.Type {
+	case "":
+		log.Println("Warning: no session policy, authenticated users may join any session of their instance")
+		return allowAllSessions{}, nil
+	case SESSION_POLICY_TYPE_CLAIM:
+		if policy.Claim == "" {
+			return nil, errors.New("claim session policy requires a claim")
+		}
+		return &ClaimSessionPolicy{Claim: policy.Claim}, nil
+	case SESSION_POLICY_TYPE_ACL:
+		if policy.ACLFile == "" {
+			return nil, errors.New("acl session policy requires an aclFile")
+		}
+		return LoadACLSessionPolicy(policy.ACLFile)
+	case SESSION_POLICY_TYPE_HTTP:
+		timeout := defaultSessionPolicyTimeout
+		if policy.Timeout != nil {
+			timeout = time.Duration(*policy.Timeout)
+		}
+		return NewHTTPSessionPolicy(policy.URL, timeout)
+	default:
+		return nil, fmt.Errorf("unknown session policy type: %s", policy.Type)
+	}
+}
+
+// SetSessionAccessPolicy replaces the session access policy
+func SetSessionAccessPolicy(policy SessionAccessPolicy) {
+	currentSessionPolicyMux.Lock()
+	defer currentSessionPolicyMux.Unlock()
+	currentSessionPolicy = policy
+}
+
+// CheckSessionAccess checks that the user may join the session of its
+// instance with the current session access policy
+func CheckSessionAccess(ctx context.Context, identity *Identity, sessionID string) error {
+	currentSessionPolicyMux.RLock()
+	policy := currentSessionPolicy
+	currentSessionPolicyMux.RUnlock()
+	return policy.CheckSessionAccess(ctx, identity, sessionID)
+}
@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"learnLoop/main/config"
+)
+
+func TestSessionAccessPolicies(t *testing.T) {
+	aclFile := filepath.Join(t.TempDir(), "acl.json")
+	err := os.WriteFile(aclFile, []byte(`{
+		"acme": {
+			"1": {"users": ["alice"]},
+			"*": {"roles": ["facilitator"]}
+		},
+		"open": {"1": {"users": ["*"]}}
+	}`), 0o600)
+	if err != nil {
+		t.Fatalf("Failed to write ACL file: %v", err)
+	}
+	aclPolicy, err := LoadACLSessionPolicy(aclFile)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request SessionAccessRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch request.SessionID {
+		case "1":
+			w.WriteHeader(http.StatusNoContent)
+		case "broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer endpoint.Close()
+	httpPolicy, err := NewHTTPSessionPolicy(endpoint.URL, time.Second)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	alice := &Identity{
+		UserID: "alice", InstanceName: "acme", Roles: []string{"learner"},
+		Claims: map[string]any{"sessions": []any{"1", "2"}},
+	}
+	facilitator := &Identity{
+		UserID: "bob", InstanceName: "acme", Roles: []string{"facilitator"},
+		Claims: map[string]any{"sessions": "*"},
+	}
+	stranger := &Identity{UserID: "carol", InstanceName: "open", Claims: map[string]any{}}
+
+	tests := []struct {
+		name      string
+		policy    SessionAccessPolicy
+		identity  *Identity
+		sessionID string
+		denied    bool
+		failed    bool
+	}{
+		{"Claim lists the session", &ClaimSessionPolicy{Claim: "sessions"}, alice, "2", false, false},
+		{"Claim does not list the session", &ClaimSessionPolicy{Claim: "sessions"}, alice, "3", true, false},
+		{"Claim allows any session", &ClaimSessionPolicy{Claim: "sessions"}, facilitator, "3", false, false},
+		{"Claim is missing", &ClaimSessionPolicy{Claim: "sessions"}, stranger, "1", true, false},
+		{"ACL lists the user", aclPolicy, alice, "1", false, false},
+		{"ACL does not list the user", aclPolicy, alice, "2", true, false},
+		{"ACL lists the role for all sessions", aclPolicy, facilitator, "2", false, false},
+		{"ACL allows any user", aclPolicy, stranger, "1", false, false},
+		{"ACL of another instance", aclPolicy, &Identity{UserID: "alice", InstanceName: "other"}, "1", true, false},
+		{"Endpoint allows", httpPolicy, alice, "1", false, false},
+		{"Endpoint denies", httpPolicy, alice, "2", true, false},
+		{"Endpoint fails", httpPolicy, alice, "broken", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.CheckSessionAccess(context.Background(), tt.identity, tt.sessionID)
+			if denied := errors.Is(err, ErrSessionAccessDenied); denied != tt.denied {
+				t.Errorf("Expected denied %v, got %v", tt.denied, err)
+			}
+			if failed := err != nil && !errors.Is(err, ErrSessionAccessDenied); failed != tt.failed {
+				t.Errorf("Expected failure %v, got %v", tt.failed, err)
+			}
+		})
+	}
+}
+
+func TestNewSessionAccessPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  config.SessionPolicy
+		wantErr bool
+	}{
+		{"No policy", config.SessionPolicy{}, false},
+		{"Claim policy", config.SessionPolicy{Type: SESSION_POLICY_TYPE_CLAIM, Claim: "sessions"}, false},
+		{"Claim policy without claim", config.SessionPolicy{Type: SESSION_POLICY_TYPE_CLAIM}, true},
+		{"ACL policy without file", config.SessionPolicy{Type: SESSION_POLICY_TYPE_ACL}, true},
+		{"ACL policy with a missing file", config.SessionPolicy{Type: SESSION_POLICY_TYPE_ACL, ACLFile: "missing.json"}, true},
+		{"HTTP policy", config.SessionPolicy{Type: SESSION_POLICY_TYPE_HTTP, URL: "http://127.0.0.1:9000/policy"}, false},
+		{"HTTP policy without URL", config.SessionPolicy{Type: SESSION_POLICY_TYPE_HTTP}, true},
+		{"Unknown type", config.SessionPolicy{Type: "ldap"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewSessionAccessPolicy(tt.policy)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
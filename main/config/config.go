@@ -1,20 +1,324 @@
 package config
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
 )
 
+// IssuerConfig describes a trusted token issuer
+type IssuerConfig struct {
+	// Issuer is the expected iss claim of the tokens
+	Issuer string `json:"issuer"`
+	// JWKSURL is the URL of the JSON Web Key Set of the issuer,
+	// derived from the issuer if it is a did:web issuer and JWKSURL is empty
+	JWKSURL string `json:"jwksUrl,omitempty"`
+	// PublicKeys are PEM encoded public keys indexed by key ID, used instead
+	// of fetching the JWKS of the issuer
+	PublicKeys map[string]string `json:"publicKeys,omitempty"`
+}
+
+// Duration is a time.Duration written as a string like "30s" in JSON
+type Duration time.Duration
+
+// UnmarshalJSON parses a duration string like "30s" or "1h"
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("duration must be a string like \"30s\": %w", err)
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+// ValidationPolicy are the rules that a verified token must follow
+type ValidationPolicy struct {
+	// Audiences are the accepted aud claims, required outside dev mode where
+	// any audience is accepted if empty
+	Audiences []string `json:"audiences,omitempty"`
+	// Algorithms are the accepted alg headers
+	Algorithms []string `json:"algorithms,omitempty"`
+	// Leeway is the clock skew tolerated when checking exp, nbf and iat
+	Leeway *Duration `json:"leeway,omitempty"`
+	// RequiredClaims are the claims that must be present and not empty
+	RequiredClaims []string `json:"requiredClaims,omitempty"`
+	// MaxTokenAge is the maximum time since iat, no maximum if zero
+	MaxTokenAge *Duration `json:"maxTokenAge,omitempty"`
+}
+
+// SessionPolicy decides which sessions a user may join
+type SessionPolicy struct {
+	// Type is claim, acl or http, any user may join any session if empty
+	Type string `json:"type,omitempty"`
+	// Claim is the claim listing the session IDs a user may join, for the
+	// claim type
+	Claim string `json:"claim,omitempty"`
+	// ACLFile is the path of the JSON access control list, for the acl type
+	ACLFile string `json:"aclFile,omitempty"`
+	// URL is the policy endpoint, for the http type
+	URL string `json:"url,omitempty"`
+	// Timeout is the timeout of the requests to the policy endpoint
+	Timeout *Duration `json:"timeout,omitempty"`
+}
+
+// InstanceConfig is the configuration of a tenant, identified by the
+// InstanceName of its users
+type InstanceConfig struct {
+	// AllowedOrigins replace the global allowed origins for the instance
+	AllowedOrigins []string `json:"allowedOrigins,omitempty"`
+	// MaxSessions is the maximum number of open sessions, no maximum if zero
+	MaxSessions int `json:"maxSessions,omitempty"`
+	// MaxConnections is the maximum number of connections, no maximum if zero
+	MaxConnections int `json:"maxConnections,omitempty"`
+	// AllowedQuizzes are the IDs of the quizzes the instance may start, any
+	// quiz if nil
+	AllowedQuizzes []int `json:"allowedQuizzes,omitempty"`
+}
+
+// AllowsQuiz tells whether the instance may start the quiz
+func (instance InstanceConfig) AllowsQuiz(quizID int) bool {
+	return instance.AllowedQuizzes == nil || slices.Contains(instance.AllowedQuizzes, quizID)
+}
+
+// File is the content of the JSON configuration file
+type File struct {
+	TrustedIssuers   []IssuerConfig            `json:"trustedIssuers"`
+	ValidationPolicy *ValidationPolicy         `json:"validationPolicy,omitempty"`
+	AllowedOrigins   []string                  `json:"allowedOrigins,omitempty"`
+	Instances        map[string]InstanceConfig `json:"instances,omitempty"`
+	SessionPolicy    *SessionPolicy            `json:"sessionPolicy,omitempty"`
+}
+
 var (
-	Addr    = ":8080"
-	DevMode = false
+	Addr               = ":8080"
+	DevMode            = false
+	ConfigFile         = ""
+	SessionIdleTimeout = 30 * time.Minute
+	ReplayBufferSize   = 128
+	RoleClaim          = "role"
+	DefaultRole        = "learner"
+	LoginClaim         = "preferred_username"
+	NameClaim          = "name"
+
+	// TrustedIssuers are the only issuers whose tokens are accepted
+	TrustedIssuers       = []IssuerConfig{}
+	JWKSFetchTimeout     = 5 * time.Second
+	JWKSMaxResponseBytes = int64(1 << 20)
+	// JWKSDefaultTTL is the lifetime of a JWKS without Cache-Control max-age
+	JWKSDefaultTTL = time.Hour
+	// JWKSMaxTTL caps the lifetime given by Cache-Control max-age
+	JWKSMaxTTL = 24 * time.Hour
+	// JWKSMinRefetchInterval is the minimum interval between two requests of
+	// the same JWKS, and the minimum lifetime of a JWKS
+	JWKSMinRefetchInterval = 30 * time.Second
+
+	// QuizDir is the directory of the quiz JSON files
+	QuizDir = "main/data"
+	// QuizReloadInterval is the interval at which the quiz directory is
+	// checked for changes, never if zero
+	QuizReloadInterval = 5 * time.Second
+
+	// RevocationFile is the file persisting the revoked tokens and banned
+	// users, kept in memory only if empty
+	RevocationFile = ""
+
+	// ConnectTicketTTL is the lifetime of the one-time tickets used to open a
+	// connection instead of a token
+	ConnectTicketTTL = 30 * time.Second
+
+	// AllowedOrigins are the origins of the pages allowed to open a
+	// connection, like "https://app.example.com" or "*.example.com"
+	AllowedOrigins = []string{}
+	// Instances are the configurations of the tenants by instance name
+	Instances = map[string]InstanceConfig{}
+
+	// SessionAccessPolicy decides which sessions a user may join
+	SessionAccessPolicy = SessionPolicy{}
+
+	// TokenPolicy is the validation policy of tokens, overridden by the
+	// validationPolicy of the configuration file
+	TokenPolicy = DefaultValidationPolicy()
 )
 
-func Init() {
+// DefaultValidationPolicy accepts every supported algorithm with a 30s leeway
+// and requires the sub, exp and instanceBaseName claims
+func DefaultValidationPolicy() ValidationPolicy {
+	leeway := Duration(30 * time.Second)
+	maxTokenAge := Duration(0)
+	return ValidationPolicy{
+		Algorithms: []string{
+			"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA",
+		},
+		Leeway:         &leeway,
+		RequiredClaims: []string{"sub", "exp", "instanceBaseName"},
+		MaxTokenAge:    &maxTokenAge,
+	}
+}
+
+// merge overrides the policy with the fields set in other
+func (policy *ValidationPolicy) merge(other *ValidationPolicy) {
+	if other.Audiences != nil {
+		policy.Audiences = other.Audiences
+	}
+	if other.Algorithms != nil {
+		policy.Algorithms = other.Algorithms
+	}
+	if other.Leeway != nil {
+		policy.Leeway = other.Leeway
+	}
+	if other.RequiredClaims != nil {
+		policy.RequiredClaims = other.RequiredClaims
+	}
+	if other.MaxTokenAge != nil {
+		policy.MaxTokenAge = other.MaxTokenAge
+	}
+}
+
+func Init() error {
 	addr := flag.String("addr", Addr, "http service address")
 	devMode := flag.Bool("dev", DevMode, "development mode")
+	configFile := flag.String("config", ConfigFile, "path of the JSON configuration file")
+	sessionIdleTimeout := flag.Duration(
+		"session-idle-timeout", SessionIdleTimeout,
+		"duration after which a session without any connected client is closed",
+	)
+	replayBufferSize := flag.Int(
+		"replay-buffer-size", ReplayBufferSize,
+		"number of frames kept per session to be replayed to reconnecting clients",
+	)
+	roleClaim := flag.String(
+		"role-claim", RoleClaim,
+		"name of the JWT claim containing the roles or permissions of the user",
+	)
+	defaultRole := flag.String(
+		"default-role", DefaultRole,
+		"role given to users whose token does not contain the role claim",
+	)
+	loginClaim := flag.String(
+		"login-claim", LoginClaim,
+		"name of the JWT claim containing the login of the user, the user ID is used if missing",
+	)
+	nameClaim := flag.String(
+		"name-claim", NameClaim,
+		"name of the JWT claim containing the display name of the user, the login is used if missing",
+	)
+	trustedIssuers := flag.String(
+		"trusted-issuers", "",
+		"comma separated list of trusted did:web issuers, in addition to the ones of the configuration file",
+	)
+	jwksFetchTimeout := flag.Duration("jwks-fetch-timeout", JWKSFetchTimeout, "timeout of JWKS requests")
+	jwksMaxResponseBytes := flag.Int64(
+		"jwks-max-response-bytes", JWKSMaxResponseBytes, "maximum size of JWKS responses",
+	)
+	jwksDefaultTTL := flag.Duration(
+		"jwks-default-ttl", JWKSDefaultTTL,
+		"lifetime of a JWKS whose response has no Cache-Control max-age",
+	)
+	jwksMaxTTL := flag.Duration("jwks-max-ttl", JWKSMaxTTL, "maximum lifetime of a JWKS")
+	jwksMinRefetchInterval := flag.Duration(
+		"jwks-min-refetch-interval", JWKSMinRefetchInterval,
+		"minimum interval between two requests of the same JWKS, when a token has an unknown key ID",
+	)
+	audiences := flag.String(
+		"audiences", "",
+		"comma separated list of accepted aud claims, in addition to the ones of the configuration file",
+	)
+	allowedOrigins := flag.String(
+		"allowed-origins", "",
+		"comma separated list of origins allowed to open a connection, in addition to the ones of the configuration file",
+	)
+	quizDir := flag.String("quiz-dir", QuizDir, "directory of the quiz JSON files")
+	quizReloadInterval := flag.Duration(
+		"quiz-reload-interval", QuizReloadInterval,
+		"interval at which the quiz directory is checked for changes, never if zero",
+	)
+	revocationFile := flag.String(
+		"revocation-file", RevocationFile,
+		"path of the JSON file persisting the revoked tokens and banned users, kept in memory only if empty",
+	)
+	connectTicketTTL := flag.Duration(
+		"connect-ticket-ttl", ConnectTicketTTL, "lifetime of the one-time tickets used to open a connection",
+	)
 
 	flag.Parse()
 
 	Addr = *addr
 	DevMode = *devMode
+	ConfigFile = *configFile
+	SessionIdleTimeout = *sessionIdleTimeout
+	ReplayBufferSize = *replayBufferSize
+	RoleClaim = *roleClaim
+	DefaultRole = *defaultRole
+	LoginClaim = *loginClaim
+	NameClaim = *nameClaim
+	JWKSFetchTimeout = *jwksFetchTimeout
+	JWKSMaxResponseBytes = *jwksMaxResponseBytes
+	JWKSDefaultTTL = *jwksDefaultTTL
+	JWKSMaxTTL = *jwksMaxTTL
+	JWKSMinRefetchInterval = *jwksMinRefetchInterval
+	ConnectTicketTTL = *connectTicketTTL
+	RevocationFile = *revocationFile
+	QuizDir = *quizDir
+	QuizReloadInterval = *quizReloadInterval
+
+	if ConfigFile != "" {
+		file, err := Load(ConfigFile)
+		if err != nil {
+			return err
+		}
+		TrustedIssuers = append(TrustedIssuers, file.TrustedIssuers...)
+		if file.ValidationPolicy != nil {
+			TokenPolicy.merge(file.ValidationPolicy)
+		}
+		AllowedOrigins = append(AllowedOrigins, file.AllowedOrigins...)
+		if file.Instances != nil {
+			Instances = file.Instances
+		}
+		if file.SessionPolicy != nil {
+			SessionAccessPolicy = *file.SessionPolicy
+		}
+	}
+	for _, issuer := range strings.Split(*trustedIssuers, ",") {
+		if issuer = strings.TrimSpace(issuer); issuer != "" {
+			TrustedIssuers = append(TrustedIssuers, IssuerConfig{Issuer: issuer})
+		}
+	}
+	for _, origin := range strings.Split(*allowedOrigins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			AllowedOrigins = append(AllowedOrigins, origin)
+		}
+	}
+	for _, audience := range strings.Split(*audiences, ",") {
+		if audience = strings.TrimSpace(audience); audience != "" {
+			TokenPolicy.Audiences = append(TokenPolicy.Audiences, audience)
+		}
+	}
+	// without audience, the tokens that a trusted issuer minted for another
+	// service would be accepted
+	if len(TokenPolicy.Audiences) == 0 && !DevMode {
+		return errors.New("no accepted audience, set the audiences of the validationPolicy or -audiences")
+	}
+	return nil
+}
+
+// Load reads the JSON configuration file
+func Load(path string) (*File, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading configuration file %s: %w", path, err)
+	}
+	var file File
+	if err := json.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("error parsing configuration file %s: %w", path, err)
+	}
+	return &file, nil
 }
@@ -1,23 +1,22 @@
 package main
 
 import (
-	_ "embed"
 	"fmt"
 	"log"
 	"net/http"
 
+	"learnLoop/main/admin"
+	"learnLoop/main/auth"
 	"learnLoop/main/config"
 	"learnLoop/main/models"
 	"learnLoop/main/websocket"
 )
 
-//go:embed data/quiz1.json
-var quiz1Model string
-
-var quiz1 *models.Quiz
+var quizzes *models.QuizRepository
 
 func serveHome(w http.ResponseWriter, r *http.Request) {
-	log.Println(r.URL)
+	// the query may contain credentials, only the path is logged
+	log.Println(r.URL.Path)
 	if r.URL.Path != "/" {
 		http.Error(w, "Not found", http.StatusNotFound)
 		return
@@ -29,16 +28,30 @@ func serveHome(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, "home.html")
 }
 
+// clientConnectHandler sends the state of the session to the client that
+// just connected, so that it can take part in the running quiz right away.
+func clientConnectHandler(client *websocket.Client) error {
+	session := client.Hub.GetSession(client.User.SessionKey())
+	if session == nil {
+		return fmt.Errorf("unknown session: %s", client.User.SessionID)
+	}
+	var err error
+	doErr := session.Do(func() {
+		err = models.SendSessionSnapshot(client.User, session, commandServices)
+	})
+	if doErr != nil {
+		return doErr
+	}
+	return err
+}
+
 func clientCloseHandler(client *websocket.Client) error {
 	// send close message of this user to all users
-	err := websocket.SendMessageToAllClients(client.Hub, models.UserDisconnectMessage{
+	err := websocket.SendMessage(client.Hub, client.User.SessionKey(), models.UserDisconnectMessage{
 		Envelope: &models.Envelope{
 			Type: models.MESSAGE_TYPE_USER_DISCONNECTED,
 		},
-		From: models.Recipient{
-			Type: models.RECIPIENT_TYPE_LEARNER,
-			Id:   client.User.Login,
-		},
+		From: client.User.Recipient(),
 		To: []models.Recipient{
 			{
 				Type: models.RECIPIENT_TYPE_SESSION,
@@ -54,19 +67,36 @@ func clientCloseHandler(client *websocket.Client) error {
 
 var commandServices = models.CommandServices{
 	MessageSender: func(user *models.User, message interface{}) error {
-		return websocket.SendMessageToAllClients(hub, message)
+		return websocket.SendMessage(hub, user.SessionKey(), message)
+	},
+
+	RecipientsSender: func(user *models.User, to []models.Recipient, message interface{}) error {
+		return websocket.SendMessageToRecipients(hub, user.SessionKey(), to, message)
+	},
+
+	Reply: func(user *models.User, message interface{}) error {
+		return websocket.SendMessageToUser(hub, user, message)
+	},
+
+	GetUsersInSession: func(key models.SessionKey) []*models.User {
+		return hub.GetUsersInSession(key)
+	},
+
+	GetLastSeq: func(key models.SessionKey) uint64 {
+		return hub.LastSeq(key)
+	},
+
+	Replay: func(user *models.User, lastSeq uint64) bool {
+		return hub.Replay(user, lastSeq)
 	},
 
 	SendUserConnectMessageForAllUsersInSession: func(session *models.Session) error {
-		for _, user := range hub.GetUsersInSession(session.SessionID) {
-			err := websocket.SendMessageToAllClients(hub, models.UserConnectMessage{
+		for _, user := range hub.GetUsersInSession(session.Key()) {
+			err := websocket.SendMessage(hub, session.Key(), models.UserConnectMessage{
 				Envelope: &models.Envelope{
 					Type: models.MESSAGE_TYPE_USER_CONNECTED,
 				},
-				From: models.Recipient{
-					Type: models.RECIPIENT_TYPE_LEARNER,
-					Id:   user.Login,
-				},
+				From: user.Recipient(),
 				To: []models.Recipient{
 					{
 						Type: models.RECIPIENT_TYPE_SESSION,
@@ -81,55 +111,155 @@ var commandServices = models.CommandServices{
 		return nil
 	},
 
-	GetQuiz: func(quizId int) (*models.Quiz, error) {
-		if quizId != 1 {
-			return nil, fmt.Errorf("unknown quiz ID: %d", quizId)
+	RefreshAuth: func(user *models.User, token string) error {
+		return websocket.RefreshAuth(hub, user, token)
+	},
+
+	GetQuiz: func(instanceName string, quizId int) (*models.Quiz, error) {
+		if !config.Instances[instanceName].AllowsQuiz(quizId) {
+			return nil, fmt.Errorf("quiz %d is not allowed for instance %s", quizId, instanceName)
 		}
-		return quiz1, nil
+		return quizzes.GetQuiz(quizId)
 	},
 }
 
-func clientMessageHandler(client *websocket.Client, message []byte) error {
+func executeCommand(client *websocket.Client, message []byte) error {
 	command, err := models.ParseCommand(message)
 	if err != nil {
-		return fmt.Errorf("error parsing message: %v", err)
+		return fmt.Errorf("error parsing message: %w", err)
 	}
 	if command != nil {
-		err := (*command).Execute(
-			client.User,
-			client.Hub.GetSession(client.User.SessionID),
-			commandServices,
-		)
+		if err := models.Authorize(client.User, *command); err != nil {
+			return err
+		}
+		if connectionCommand, ok := (*command).(models.ConnectionCommand); ok {
+			if err := connectionCommand.ExecuteOnConnection(client.User, commandServices); err != nil {
+				return fmt.Errorf("error executing command: %w", err)
+			}
+			return nil
+		}
+		session := client.Hub.GetSession(client.User.SessionKey())
+		if session == nil {
+			return fmt.Errorf("unknown session: %s", client.User.SessionID)
+		}
+		// commands are executed on the session goroutine
+		doErr := session.Do(func() {
+			err = (*command).Execute(client.User, session, commandServices)
+		})
+		if doErr != nil {
+			return fmt.Errorf("error executing command: %w", doErr)
+		}
 		if err != nil {
-			return fmt.Errorf("error executing command: %v", err)
+			return fmt.Errorf("error executing command: %w", err)
 		}
 	}
 	return err
 }
 
+// clientMessageHandler executes the command sent by the client and replies
+// with an error message on failure, or an ack message if the client
+// identified its message with a client ID.
+func clientMessageHandler(client *websocket.Client, message []byte) error {
+	clientId := models.ParseClientId(message)
+	err := executeCommand(client, message)
+	if err != nil {
+		replyErr := websocket.SendMessageToUser(client.Hub, client.User, models.NewErrorMessage(clientId, err))
+		if replyErr != nil {
+			log.Printf("Error sending ErrorMessage: %v\n", replyErr)
+		}
+		return err
+	}
+	if clientId != "" {
+		return websocket.SendMessageToUser(client.Hub, client.User, models.NewAckMessage(clientId))
+	}
+	return nil
+}
+
 var hub *websocket.Hub = nil
 
+// newDevIdentityProvider creates the development identity provider and
+// trusts its issuer, whose JWKS is served by this server
+func newDevIdentityProvider() *auth.DevIdentityProvider {
+	issuer, err := auth.DevIssuer(config.Addr)
+	if err != nil {
+		log.Fatalf("Failed to create dev identity provider: %v", err)
+	}
+	provider, err := auth.NewDevIdentityProvider(issuer)
+	if err != nil {
+		log.Fatalf("Failed to create dev identity provider: %v", err)
+	}
+	// the key of the provider is trusted directly, the server never fetches
+	// its own JWKS
+	issuerConfig, err := provider.IssuerConfig()
+	if err != nil {
+		log.Fatalf("Failed to create dev identity provider: %v", err)
+	}
+	config.TrustedIssuers = append(config.TrustedIssuers, issuerConfig)
+	log.Printf("Dev identity provider %s mints tokens on POST %s", issuer, auth.DevTokenPath)
+	return provider
+}
+
 func main() {
-	config.Init()
+	if err := config.Init(); err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	var devIdentityProvider *auth.DevIdentityProvider
+	if config.DevMode {
+		devIdentityProvider = newDevIdentityProvider()
+	}
+	if err := auth.LoadTrustedIssuers(config.TrustedIssuers); err != nil {
+		log.Fatalf("Failed to load trusted issuers: %v", err)
+	}
+	if err := auth.LoadValidationPolicy(config.TokenPolicy); err != nil {
+		log.Fatalf("Failed to load token validation policy: %v", err)
+	}
+	sessionAccessPolicy, err := auth.NewSessionAccessPolicy(config.SessionAccessPolicy)
+	if err != nil {
+		log.Fatalf("Failed to load session access policy: %v", err)
+	}
+	auth.SetSessionAccessPolicy(sessionAccessPolicy)
+	revocations, err := auth.NewRevocationStore(config.RevocationFile)
+	if err != nil {
+		log.Fatalf("Failed to load revocations: %v", err)
+	}
+	auth.SetRevocationStore(revocations)
+	if err := websocket.LoadOriginPolicy(config.AllowedOrigins, config.Instances); err != nil {
+		log.Fatalf("Failed to load allowed origins: %v", err)
+	}
+	if config.DevMode {
+		log.Println("Warning: connections from any origin are accepted in dev mode")
+	}
 
-	// Parse the embedded quiz JSON
-	var err error
-	quiz1, err = models.ParseQuiz(quiz1Model)
+	quizzes, err = models.NewQuizRepository(config.QuizDir)
 	if err != nil {
-		log.Fatalf("Failed to parse quiz1: %v", err)
+		log.Fatalf("Failed to load quizzes: %v", err)
+	}
+	log.Printf("Loaded %d quiz(zes) from %s", len(quizzes.Quizzes()), config.QuizDir)
+	if config.QuizReloadInterval > 0 {
+		go quizzes.Watch(config.QuizReloadInterval)
 	}
-	log.Printf("Loaded quiz: %s with %d questions", quiz1.Title, len(quiz1.Questions))
 
-	hub = websocket.NewHub()
+	hub = websocket.NewHub(config.SessionIdleTimeout, config.ReplayBufferSize)
 	if config.DevMode {
 		log.Printf("Starting server on port %s in dev mode\n", config.Addr)
 	} else {
 		log.Printf("Starting server on port %s in production mode\n", config.Addr)
 	}
 	go hub.Run()
+	revocations.OnRevoke(func(revocation auth.Revocation) {
+		websocket.DisconnectRevoked(hub, revocation)
+	})
+	adminHandler := &admin.Handler{Revocations: revocations}
 	http.HandleFunc("/", serveHome)
+	if devIdentityProvider != nil {
+		http.HandleFunc(auth.DevJWKSPath, devIdentityProvider.ServeJWKS)
+		http.HandleFunc(auth.DevTokenPath, devIdentityProvider.ServeToken)
+	}
+	http.HandleFunc(admin.RevocationsPath, adminHandler.ServeRevocations)
+	http.HandleFunc(admin.RejectionsPath, adminHandler.ServeRejections)
+	http.HandleFunc("/ws/ticket", websocket.ServeConnectTicket)
 	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
-		websocket.ServeWs(hub, w, r, clientCloseHandler, clientMessageHandler)
+		websocket.ServeWs(hub, w, r, clientConnectHandler, clientCloseHandler, clientMessageHandler)
 	})
 	err = http.ListenAndServe(config.Addr, nil)
 	if err != nil {
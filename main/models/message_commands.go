@@ -2,23 +2,91 @@ package models
 
 import (
 	"fmt"
+	"strings"
 	"time"
 )
 
 type Command interface {
 	Execute(user *User, session *Session, commandServices CommandServices) error
+	// RequiredPermission is the permission the user needs to execute the command
+	RequiredPermission() Permission
 }
 
+// ConnectionCommand is a command about the connection of the user rather
+// than its session, executed outside of the session goroutine
+type ConnectionCommand interface {
+	ExecuteOnConnection(user *User, commandServices CommandServices) error
+}
+
+// Authorize checks the user has the permission required by the command
+func Authorize(user *User, command Command) error {
+	permission := command.RequiredPermission()
+	if !user.HasPermission(permission) {
+		return newCommandError(
+			ERROR_CODE_FORBIDDEN,
+			"user %s is not allowed to send %T, permission %s required", user.Login, command, permission,
+		)
+	}
+	return nil
+}
+
+func (msg *UserConnectMessage) RequiredPermission() Permission       { return PERMISSION_NONE }
+func (msg *UserDisconnectMessage) RequiredPermission() Permission    { return PERMISSION_NONE }
+func (msg *ResumeMessage) RequiredPermission() Permission            { return PERMISSION_NONE }
+func (msg *RefreshAuthMessage) RequiredPermission() Permission       { return PERMISSION_NONE }
+func (msg *Message) RequiredPermission() Permission                  { return PERMISSION_CHAT }
+func (msg *QuizStartMessage) RequiredPermission() Permission         { return PERMISSION_QUIZ_CONTROL }
+func (msg *QuizNextQuestionMessage) RequiredPermission() Permission  { return PERMISSION_QUIZ_CONTROL }
+func (msg *QuizQuestionMessage) RequiredPermission() Permission      { return PERMISSION_NONE }
+func (msg *QuizLearnerAnswerMessage) RequiredPermission() Permission { return PERMISSION_QUIZ_ANSWER }
+func (msg *QuizLearnerAnswerFreeTextMessage) RequiredPermission() Permission {
+	return PERMISSION_QUIZ_ANSWER
+}
+func (msg *QuizQuestionStatsMessage) RequiredPermission() Permission { return PERMISSION_NONE }
+func (msg *QuizQuestionEndMessage) RequiredPermission() Permission   { return PERMISSION_NONE }
+func (msg *QuizLeaderboardMessage) RequiredPermission() Permission   { return PERMISSION_NONE }
+
 type CommandServices struct {
 	MessageSender                              func(user *User, message interface{}) error
+	RecipientsSender                           func(user *User, to []Recipient, message interface{}) error
+	Reply                                      func(user *User, message interface{}) error
 	SendUserConnectMessageForAllUsersInSession func(session *Session) error
-	GetQuiz                                    func(quizId int) (quiz *Quiz, err error)
+	GetUsersInSession                          func(key SessionKey) []*User
+	GetLastSeq                                 func(key SessionKey) uint64
+	Replay                                     func(user *User, lastSeq uint64) bool
+	// GetQuiz returns a quiz available to the given instance
+	GetQuiz func(instanceName string, quizId int) (quiz *Quiz, err error)
+	// RefreshAuth validates a new token of the user and extends its connection
+	// until the token expires
+	RefreshAuth func(user *User, token string) error
+}
+
+func (msg *RefreshAuthMessage) Execute(
+	user *User, session *Session, commandServices CommandServices,
+) error {
+	return msg.ExecuteOnConnection(user, commandServices)
+}
+
+func (msg *RefreshAuthMessage) ExecuteOnConnection(user *User, commandServices CommandServices) error {
+	if msg.Token == "" {
+		return newCommandError(ERROR_CODE_INVALID_TOKEN, "token is missing")
+	}
+	if err := commandServices.RefreshAuth(user, msg.Token); err != nil {
+		return &CommandError{Code: ERROR_CODE_INVALID_TOKEN, Err: err}
+	}
+	return nil
 }
 
 func (msg *UserConnectMessage) Execute(
 	user *User, session *Session, commandServices CommandServices,
 ) error {
-	user.Login = msg.From.Id
+	// the identity of the user comes from its token, From is ignored
+	if msg.Nickname != "" {
+		err := user.SetNickname(msg.Nickname, commandServices.GetUsersInSession(session.Key()))
+		if err != nil {
+			return err
+		}
+	}
 	return commandServices.SendUserConnectMessageForAllUsersInSession(session)
 }
 
@@ -28,14 +96,110 @@ func (msg *UserDisconnectMessage) Execute(
 	return nil
 }
 
+func (msg *ResumeMessage) Execute(
+	user *User, session *Session, commandServices CommandServices,
+) error {
+	if commandServices.Replay(user, msg.LastSeq) {
+		return nil
+	}
+	// missed frames are not available anymore, send the session state instead
+	return SendSessionSnapshot(user, session, commandServices)
+}
+
+// SendSessionSnapshot sends the state of the session, including the running
+// quiz question, to the connection of the given user.
+// It must be called from the session goroutine.
+func SendSessionSnapshot(user *User, session *Session, commandServices CommandServices) error {
+	return commandServices.Reply(user, newSessionSnapshotMessage(user, session, commandServices))
+}
+
+func newSessionSnapshotMessage(
+	user *User, session *Session, commandServices CommandServices,
+) *SessionSnapshotMessage {
+	// the sequence number is read first, so that no frame is missed by the client
+	lastSeq := commandServices.GetLastSeq(session.Key())
+	users := []Recipient{}
+	for _, u := range commandServices.GetUsersInSession(session.Key()) {
+		users = append(users, u.Recipient())
+	}
+	return &SessionSnapshotMessage{
+		Envelope: &Envelope{
+			Type: MESSAGE_TYPE_SESSION_SNAPSHOT,
+		},
+		SessionID: session.SessionID,
+		State:     session.State(),
+		LastSeq:   lastSeq,
+		Users:     users,
+		Quiz:      session.QuizGame.Snapshot(user, time.Now()),
+	}
+}
+
 func (msg *Message) Execute(
 	user *User, session *Session, commandServices CommandServices,
 ) error {
-	msg.From = Recipient{
-		Type: RECIPIENT_TYPE_LEARNER,
-		Id:   user.Login,
+	msg.From = user.Recipient()
+	if !msg.isPrivate() {
+		return commandServices.MessageSender(user, msg)
 	}
-	return commandServices.MessageSender(user, msg)
+	return sendPrivateMessage(msg, user, commandServices)
+}
+
+// isPrivate returns true if the message is only addressed to learners
+func (msg *Message) isPrivate() bool {
+	if len(msg.To) == 0 {
+		return false
+	}
+	for _, recipient := range msg.To {
+		if recipient.Type != RECIPIENT_TYPE_LEARNER {
+			return false
+		}
+	}
+	return true
+}
+
+// findUserInSession returns the user with the given user ID. The login is
+// not unique, it may be the user ID of another user.
+func findUserInSession(users []*User, id string) *User {
+	for _, user := range users {
+		if user.UserID == id {
+			return user
+		}
+	}
+	return nil
+}
+
+// sendPrivateMessage sends the message to the connected learners of the
+// sender session only, the sender receiving a copy of it.
+// An error is returned if a recipient is not connected.
+func sendPrivateMessage(msg *Message, user *User, commandServices CommandServices) error {
+	users := commandServices.GetUsersInSession(user.SessionKey())
+	to := []Recipient{}
+	missing := []string{}
+	for _, recipient := range msg.To {
+		target := findUserInSession(users, recipient.Id)
+		if target == nil {
+			missing = append(missing, recipient.Id)
+			continue
+		}
+		to = append(to, target.Recipient())
+	}
+
+	if len(to) > 0 {
+		msg.To = to
+		routing := append([]Recipient{msg.From}, to...)
+		err := commandServices.RecipientsSender(user, routing, msg)
+		if err != nil {
+			return fmt.Errorf("error sending private message: %v", err)
+		}
+	}
+
+	if len(missing) > 0 {
+		return newCommandError(
+			ERROR_CODE_RECIPIENT_NOT_CONNECTED,
+			"recipient(s) not connected: %s", strings.Join(missing, ", "),
+		)
+	}
+	return nil
 }
 
 func nextQuestion(
@@ -45,22 +209,31 @@ func nextQuestion(
 	if quizMsg == nil {
 		return nil
 	}
+	if _, ended := quizMsg.(*QuizStatsMessage); ended {
+		session.SetState(SESSION_STATE_OPEN)
+	}
+	// the leaderboard of the question closed by the facilitator, if any
+	if err := sendLeaderboard(user, session, commandServices); err != nil {
+		return fmt.Errorf("error sending leaderboard: %v", err)
+	}
 	return commandServices.MessageSender(user, quizMsg)
 }
 
 func startQuiz(session *Session, commandServices CommandServices, quiz *Quiz, user *User) {
-	session.QuizGame.questionTimeout = DEFAULT_TIMEOUT_SECONDS * time.Second
+	session.QuizGame.defaultQuestionTimeout = DEFAULT_TIMEOUT_SECONDS * time.Second
 	session.QuizGame.commandServices = commandServices
-	session.QuizGame.Start(quiz, user)
+	// the game keeps its own copy, unaffected by reloads of the quiz
+	session.QuizGame.Start(quiz.Clone(), user)
+	session.SetState(SESSION_STATE_RUNNING)
 }
 
 func (msg *QuizStartMessage) Execute(
 	user *User, session *Session, commandServices CommandServices,
 ) error {
 	quizId := int(msg.QuizId)
-	quiz, err := commandServices.GetQuiz(quizId)
+	quiz, err := commandServices.GetQuiz(user.InstanceName, quizId)
 	if err != nil {
-		return fmt.Errorf("error getting quiz with id: %d", quizId)
+		return newCommandError(ERROR_CODE_UNKNOWN_QUIZ, "error getting quiz with id: %d", quizId)
 	}
 	startQuiz(session, commandServices, quiz, user)
 
@@ -70,12 +243,14 @@ func (msg *QuizStartMessage) Execute(
 func (msg *QuizNextQuestionMessage) Execute(
 	user *User, session *Session, commandServices CommandServices,
 ) error {
-	quizId := int(msg.QuizId)
-	quiz, err := commandServices.GetQuiz(quizId)
-	if err != nil {
-		return fmt.Errorf("error getting quiz with id: %d", quizId)
-	}
+	// a running game keeps its copy of the quiz, even if the quiz was
+	// removed or changed since it started
 	if session.QuizGame.quiz == nil {
+		quizId := int(msg.QuizId)
+		quiz, err := commandServices.GetQuiz(user.InstanceName, quizId)
+		if err != nil {
+			return newCommandError(ERROR_CODE_UNKNOWN_QUIZ, "error getting quiz with id: %d", quizId)
+		}
 		startQuiz(session, commandServices, quiz, user)
 	}
 
@@ -85,7 +260,7 @@ func (msg *QuizNextQuestionMessage) Execute(
 func (msg *QuizQuestionMessage) Execute(
 	user *User, session *Session, commandServices CommandServices,
 ) error {
-	return fmt.Errorf("QuizQuestionMessage can only be sent by the server")
+	return newCommandError(ERROR_CODE_SERVER_ONLY_MESSAGE, "QuizQuestionMessage can only be sent by the server")
 }
 
 func (msg *QuizLearnerAnswerMessage) Execute(
@@ -93,7 +268,7 @@ func (msg *QuizLearnerAnswerMessage) Execute(
 ) error {
 	quizQuestionStatsMessage, err := session.QuizGame.AnswerMCQuestion(msg.QuestionId, msg.Answers, user)
 	if err != nil {
-		return fmt.Errorf("error processing learner answer: %v", err)
+		return fmt.Errorf("error processing learner answer: %w", err)
 	}
 	if quizQuestionStatsMessage != nil {
 		err := commandServices.MessageSender(user, quizQuestionStatsMessage)
@@ -101,6 +276,9 @@ func (msg *QuizLearnerAnswerMessage) Execute(
 			return fmt.Errorf("error sending quiz question stats message: %v", err)
 		}
 	}
+	if err := sendLeaderboard(user, session, commandServices); err != nil {
+		return fmt.Errorf("error sending leaderboard: %v", err)
+	}
 	return nil
 }
 
@@ -109,7 +287,7 @@ func (msg *QuizLearnerAnswerFreeTextMessage) Execute(
 ) error {
 	quizQuestionStatsMessage, err := session.QuizGame.AnswerFreeTextQuestion(msg.QuestionId, msg.Answers, user)
 	if err != nil {
-		return fmt.Errorf("error processing learner answer: %v", err)
+		return fmt.Errorf("error processing learner answer: %w", err)
 	}
 	if quizQuestionStatsMessage != nil {
 		err := commandServices.MessageSender(user, quizQuestionStatsMessage)
@@ -117,17 +295,26 @@ func (msg *QuizLearnerAnswerFreeTextMessage) Execute(
 			return fmt.Errorf("error sending quiz question stats message: %v", err)
 		}
 	}
+	if err := sendLeaderboard(user, session, commandServices); err != nil {
+		return fmt.Errorf("error sending leaderboard: %v", err)
+	}
 	return nil
 }
 
+func (msg *QuizLeaderboardMessage) Execute(
+	user *User, session *Session, commandServices CommandServices,
+) error {
+	return newCommandError(ERROR_CODE_SERVER_ONLY_MESSAGE, "QuizLeaderboardMessage can only be sent by the server")
+}
+
 func (msg *QuizQuestionStatsMessage) Execute(
 	user *User, session *Session, commandServices CommandServices,
 ) error {
-	return fmt.Errorf("QuizQuestionStatsMessage can only be sent by the server")
+	return newCommandError(ERROR_CODE_SERVER_ONLY_MESSAGE, "QuizQuestionStatsMessage can only be sent by the server")
 }
 
 func (msg *QuizQuestionEndMessage) Execute(
 	user *User, session *Session, commandServices CommandServices,
 ) error {
-	return fmt.Errorf("QuizQuestionEndMessage can only be sent by the server")
+	return newCommandError(ERROR_CODE_SERVER_ONLY_MESSAGE, "QuizQuestionEndMessage can only be sent by the server")
 }
@@ -0,0 +1,335 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+type sentMessage struct {
+	to      []Recipient
+	message interface{}
+}
+
+type fakeServices struct {
+	users   []*User
+	sent    []sentMessage
+	replies []interface{}
+	quiz    *Quiz
+	lastSeq uint64
+}
+
+func (f *fakeServices) commandServices() CommandServices {
+	return CommandServices{
+		MessageSender: func(user *User, message interface{}) error {
+			f.sent = append(f.sent, sentMessage{message: message})
+			return nil
+		},
+		RecipientsSender: func(user *User, to []Recipient, message interface{}) error {
+			f.sent = append(f.sent, sentMessage{to: to, message: message})
+			return nil
+		},
+		Reply: func(user *User, message interface{}) error {
+			f.replies = append(f.replies, message)
+			return nil
+		},
+		GetUsersInSession: func(key SessionKey) []*User {
+			return f.users
+		},
+		GetLastSeq: func(key SessionKey) uint64 {
+			return f.lastSeq
+		},
+		Replay: func(user *User, lastSeq uint64) bool {
+			return f.lastSeq-lastSeq <= 2
+		},
+		GetQuiz: func(instanceName string, quizId int) (*Quiz, error) {
+			if f.quiz == nil || f.quiz.ID != quizId {
+				return nil, fmt.Errorf("unknown quiz ID: %d", quizId)
+			}
+			return f.quiz, nil
+		},
+	}
+}
+
+func TestMessageExecutePrivate(t *testing.T) {
+	facilitator := &User{UserID: "u1", Login: "facilitator", SessionID: "1"}
+	learner1 := &User{UserID: "u2", Login: "learner1", SessionID: "1"}
+	learner2 := &User{UserID: "u3", Login: "learner2", DisplayName: "Learner Two", SessionID: "1"}
+	// the login of mallory is the user ID of learner1
+	mallory := &User{UserID: "u4", Login: "u2", SessionID: "1"}
+	users := []*User{facilitator, learner1, learner2, mallory}
+
+	t.Run("Public message", func(t *testing.T) {
+		services := &fakeServices{users: users}
+		msg := &Message{
+			To:  []Recipient{{Type: RECIPIENT_TYPE_SESSION, Id: "1"}},
+			Msg: "hello",
+		}
+		err := msg.Execute(facilitator, nil, services.commandServices())
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(services.sent) != 1 || services.sent[0].to != nil {
+			t.Fatalf("Expected message to be sent to the session, got %+v", services.sent)
+		}
+	})
+
+	t.Run("Private message by user ID", func(t *testing.T) {
+		services := &fakeServices{users: users}
+		msg := &Message{
+			To: []Recipient{
+				{Type: RECIPIENT_TYPE_LEARNER, Id: "u2"},
+				{Type: RECIPIENT_TYPE_LEARNER, Id: "u3"},
+			},
+			Msg: "psst",
+		}
+		err := msg.Execute(facilitator, nil, services.commandServices())
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(services.sent) != 1 {
+			t.Fatalf("Expected 1 message sent, got %d", len(services.sent))
+		}
+		expected := []Recipient{
+			{Type: RECIPIENT_TYPE_LEARNER, Id: "u1", Name: "facilitator"},
+			{Type: RECIPIENT_TYPE_LEARNER, Id: "u2", Name: "learner1"},
+			{Type: RECIPIENT_TYPE_LEARNER, Id: "u3", Name: "Learner Two"},
+		}
+		to := services.sent[0].to
+		if len(to) != len(expected) {
+			t.Fatalf("Expected recipients %+v, got %+v", expected, to)
+		}
+		for i := range expected {
+			if to[i] != expected[i] {
+				t.Errorf("Expected recipient %+v, got %+v", expected[i], to[i])
+			}
+		}
+	})
+
+	t.Run("Private message to a disconnected learner", func(t *testing.T) {
+		services := &fakeServices{users: users}
+		msg := &Message{
+			To: []Recipient{
+				{Type: RECIPIENT_TYPE_LEARNER, Id: "u2"},
+				{Type: RECIPIENT_TYPE_LEARNER, Id: "learner2"},
+			},
+			Msg: "psst",
+		}
+		err := msg.Execute(facilitator, nil, services.commandServices())
+		if err == nil || err.Error() != "recipient(s) not connected: learner2" {
+			t.Fatalf("Expected not connected error, got %v", err)
+		}
+		if len(services.sent) != 1 {
+			t.Fatalf("Expected message to be sent to connected learner, got %+v", services.sent)
+		}
+		if ErrorCodeOf(err) != ERROR_CODE_RECIPIENT_NOT_CONNECTED {
+			t.Errorf("Expected error code %s, got %s", ERROR_CODE_RECIPIENT_NOT_CONNECTED, ErrorCodeOf(err))
+		}
+	})
+}
+
+func TestCommandErrorCodes(t *testing.T) {
+	learner := &User{Login: "learner1", SessionID: "1"}
+	quiz, _ := ParseQuiz(validQuizJSON)
+	services := &fakeServices{quiz: quiz}
+
+	session := NewSession(SessionKey{SessionID: "1"})
+	defer session.Close()
+	session.PlayerJoined()
+	session.PlayerJoined()
+
+	execute := func(command Command) error {
+		var err error
+		doErr := session.Do(func() {
+			err = command.Execute(learner, session, services.commandServices())
+		})
+		if doErr != nil {
+			t.Fatalf("Expected no error, got %v", doErr)
+		}
+		return err
+	}
+
+	tests := []struct {
+		name     string
+		command  Command
+		expected ErrorCode
+	}{
+		{"quiz not started", &QuizLearnerAnswerMessage{QuestionId: 101, Answers: []int{1001}}, ERROR_CODE_QUIZ_NOT_STARTED},
+		{"unknown quiz", &QuizStartMessage{QuizId: 2}, ERROR_CODE_UNKNOWN_QUIZ},
+		{"server only message", &QuizQuestionStatsMessage{}, ERROR_CODE_SERVER_ONLY_MESSAGE},
+		{"quiz started", &QuizStartMessage{QuizId: 1}, ""},
+		{"question mismatch", &QuizLearnerAnswerMessage{QuestionId: 102, Answers: []int{1001}}, ERROR_CODE_QUESTION_MISMATCH},
+		{"wrong question type", &QuizLearnerAnswerFreeTextMessage{QuestionId: 101, Answers: []string{"Go"}}, ERROR_CODE_WRONG_QUESTION_TYPE},
+		{"invalid answer ID", &QuizLearnerAnswerMessage{QuestionId: 101, Answers: []int{1}}, ERROR_CODE_INVALID_ANSWER},
+		{"answered", &QuizLearnerAnswerMessage{QuestionId: 101, Answers: []int{1001}}, ""},
+		{"already answered", &QuizLearnerAnswerMessage{QuestionId: 101, Answers: []int{1001}}, ERROR_CODE_ALREADY_ANSWERED},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := execute(tt.command)
+			if tt.expected == "" {
+				if err != nil {
+					t.Fatalf("Expected no error, got %v", err)
+				}
+				return
+			}
+			if ErrorCodeOf(err) != tt.expected {
+				t.Errorf("Expected error code %s, got %s (%v)", tt.expected, ErrorCodeOf(err), err)
+			}
+		})
+	}
+
+	t.Run("parse errors", func(t *testing.T) {
+		_, err := ParseCommand([]byte(`{"type": 99}`))
+		if ErrorCodeOf(err) != ERROR_CODE_UNKNOWN_MESSAGE_TYPE {
+			t.Errorf("Expected error code %s, got %s", ERROR_CODE_UNKNOWN_MESSAGE_TYPE, ErrorCodeOf(err))
+		}
+		_, err = ParseCommand([]byte(`{"type": 4, "action": 99}`))
+		if ErrorCodeOf(err) != ERROR_CODE_UNKNOWN_QUIZ_ACTION {
+			t.Errorf("Expected error code %s, got %s", ERROR_CODE_UNKNOWN_QUIZ_ACTION, ErrorCodeOf(err))
+		}
+		_, err = ParseCommand([]byte(`{"type": 2, "from":}`))
+		if ErrorCodeOf(err) != ERROR_CODE_INVALID_MESSAGE {
+			t.Errorf("Expected error code %s, got %s", ERROR_CODE_INVALID_MESSAGE, ErrorCodeOf(err))
+		}
+	})
+}
+
+func TestErrorMessage(t *testing.T) {
+	message := []byte(`{"type": 4, "action": 2, "clientId": "req-1", "questionId": 1}`)
+	err := fmt.Errorf("error executing command: %w", newCommandError(ERROR_CODE_QUIZ_ENDED, "quiz ended"))
+	msgStr, _ := json.Marshal(NewErrorMessage(ParseClientId(message), err))
+	expected := `{"type":5,"clientId":"req-1","code":"QUIZ_ENDED","error":"error executing command: quiz ended"}`
+	if string(msgStr) != expected {
+		t.Errorf("Expected message to be %s, got %s", expected, msgStr)
+	}
+
+	msgStr, _ = json.Marshal(NewAckMessage("req-1"))
+	expected = `{"type":6,"clientId":"req-1"}`
+	if string(msgStr) != expected {
+		t.Errorf("Expected message to be %s, got %s", expected, msgStr)
+	}
+}
+
+func TestResumeMessageExecute(t *testing.T) {
+	learner := &User{UserID: "u1", Login: "learner1", SessionID: "1"}
+	services := &fakeServices{users: []*User{learner}, lastSeq: 10}
+	session := NewSession(SessionKey{SessionID: "1"})
+	defer session.Close()
+
+	t.Run("Missed frames replayed", func(t *testing.T) {
+		err := (&ResumeMessage{LastSeq: 8}).Execute(learner, session, services.commandServices())
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(services.replies) != 0 {
+			t.Errorf("Expected no snapshot, got %+v", services.replies)
+		}
+	})
+
+	t.Run("Gap too large", func(t *testing.T) {
+		err := (&ResumeMessage{LastSeq: 2}).Execute(learner, session, services.commandServices())
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(services.replies) != 1 {
+			t.Fatalf("Expected a snapshot, got %+v", services.replies)
+		}
+		msgStr, _ := json.Marshal(services.replies[0])
+		expected := `{"type":8,"sessionId":"1","state":0,"lastSeq":10,"users":[{"type":1,"id":"u1","name":"learner1"}]}`
+		if string(msgStr) != expected {
+			t.Errorf("Expected message to be %s, got %s", expected, msgStr)
+		}
+	})
+}
+
+func TestUserConnectMessageExecute(t *testing.T) {
+	learner1 := &User{UserID: "u1", Login: "learner1", SessionID: "1"}
+	learner2 := &User{UserID: "u2", Login: "learner2", SessionID: "1", Nickname: "Bob"}
+	connectCount := 0
+	services := &fakeServices{users: []*User{learner1, learner2}}
+	commandServices := services.commandServices()
+	commandServices.SendUserConnectMessageForAllUsersInSession = func(session *Session) error {
+		connectCount++
+		return nil
+	}
+	session := &Session{SessionID: "1"}
+
+	t.Run("Client supplied identity is ignored", func(t *testing.T) {
+		msg := &UserConnectMessage{From: Recipient{Type: RECIPIENT_TYPE_LEARNER, Id: "learner2"}}
+		if err := msg.Execute(learner1, session, commandServices); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if learner1.Login != "learner1" || learner1.Name() != "learner1" {
+			t.Errorf("Expected identity not to change, got %+v", learner1)
+		}
+		if connectCount != 1 {
+			t.Errorf("Expected connect messages to be sent")
+		}
+	})
+
+	tests := []struct {
+		name     string
+		nickname string
+		expected ErrorCode
+	}{
+		{"nickname of another user", "bob", ERROR_CODE_NICKNAME_TAKEN},
+		{"login of another user", "Learner2", ERROR_CODE_NICKNAME_TAKEN},
+		{"blank nickname", "   ", ERROR_CODE_INVALID_NICKNAME},
+		{"too long nickname", "abcdefghijklmnopqrstuvwxyz0123456789", ERROR_CODE_INVALID_NICKNAME},
+		{"control characters", "Al\nice", ERROR_CODE_INVALID_NICKNAME},
+		{"valid nickname", " Alice ", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := (&UserConnectMessage{Nickname: tt.nickname}).Execute(learner1, session, commandServices)
+			if tt.expected == "" {
+				if err != nil {
+					t.Fatalf("Expected no error, got %v", err)
+				}
+				return
+			}
+			if ErrorCodeOf(err) != tt.expected {
+				t.Errorf("Expected error code %s, got %v", tt.expected, err)
+			}
+		})
+	}
+	if learner1.Name() != "Alice" {
+		t.Errorf("Expected nickname Alice, got %s", learner1.Name())
+	}
+}
+
+func TestRefreshAuthMessageExecute(t *testing.T) {
+	user := &User{UserID: "u1", Login: "learner1", SessionID: "1"}
+	var refreshedToken string
+	commandServices := (&fakeServices{}).commandServices()
+	commandServices.RefreshAuth = func(user *User, token string) error {
+		if token == "invalid" {
+			return fmt.Errorf("token rejected")
+		}
+		refreshedToken = token
+		return nil
+	}
+
+	command, err := ParseCommand([]byte(`{"type":9,"token":"new-token"}`))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	connectionCommand, ok := (*command).(ConnectionCommand)
+	if !ok {
+		t.Fatalf("Expected a connection command, got %T", *command)
+	}
+	if err := connectionCommand.ExecuteOnConnection(user, commandServices); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if refreshedToken != "new-token" {
+		t.Errorf("Expected token to be refreshed, got %q", refreshedToken)
+	}
+
+	for _, token := range []string{"", "invalid"} {
+		err := (&RefreshAuthMessage{Token: token}).ExecuteOnConnection(user, commandServices)
+		if ErrorCodeOf(err) != ERROR_CODE_INVALID_TOKEN {
+			t.Errorf("Expected INVALID_TOKEN error for token %q, got %v", token, err)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrorCode is a stable machine-readable code identifying why a command failed
+type ErrorCode string
+
+const (
+	ERROR_CODE_INTERNAL                ErrorCode = "INTERNAL"
+	ERROR_CODE_INVALID_MESSAGE         ErrorCode = "INVALID_MESSAGE"
+	ERROR_CODE_FORBIDDEN               ErrorCode = "FORBIDDEN"
+	ERROR_CODE_UNKNOWN_MESSAGE_TYPE    ErrorCode = "UNKNOWN_MESSAGE_TYPE"
+	ERROR_CODE_UNKNOWN_QUIZ_ACTION     ErrorCode = "UNKNOWN_QUIZ_ACTION"
+	ERROR_CODE_SERVER_ONLY_MESSAGE     ErrorCode = "SERVER_ONLY_MESSAGE"
+	ERROR_CODE_UNKNOWN_QUIZ            ErrorCode = "UNKNOWN_QUIZ"
+	ERROR_CODE_QUIZ_NOT_STARTED        ErrorCode = "QUIZ_NOT_STARTED"
+	ERROR_CODE_QUIZ_ENDED              ErrorCode = "QUIZ_ENDED"
+	ERROR_CODE_QUESTION_MISMATCH       ErrorCode = "QUESTION_MISMATCH"
+	ERROR_CODE_WRONG_QUESTION_TYPE     ErrorCode = "WRONG_QUESTION_TYPE"
+	ERROR_CODE_ALREADY_ANSWERED        ErrorCode = "ALREADY_ANSWERED"
+	ERROR_CODE_ANSWERS_NOT_OPEN        ErrorCode = "ANSWERS_NOT_OPEN"
+	ERROR_CODE_INVALID_ANSWER          ErrorCode = "INVALID_ANSWER"
+	ERROR_CODE_RECIPIENT_NOT_CONNECTED ErrorCode = "RECIPIENT_NOT_CONNECTED"
+	ERROR_CODE_INVALID_NICKNAME        ErrorCode = "INVALID_NICKNAME"
+	ERROR_CODE_NICKNAME_TAKEN          ErrorCode = "NICKNAME_TAKEN"
+	ERROR_CODE_INVALID_TOKEN           ErrorCode = "INVALID_TOKEN"
+)
+
+// CommandError is an error of a command carrying the code reported to the client
+type CommandError struct {
+	Code ErrorCode
+	Err  error
+}
+
+func newCommandError(code ErrorCode, format string, args ...any) *CommandError {
+	return &CommandError{
+		Code: code,
+		Err:  fmt.Errorf(format, args...),
+	}
+}
+
+func (e *CommandError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorCodeOf returns the code of the CommandError wrapped by err,
+// ERROR_CODE_INTERNAL if none
+func ErrorCodeOf(err error) ErrorCode {
+	var commandError *CommandError
+	if errors.As(err, &commandError) {
+		return commandError.Code
+	}
+	return ERROR_CODE_INTERNAL
+}
+
+// NewErrorMessage creates the error message reporting err to the client
+// whose message had the given client ID
+func NewErrorMessage(clientId string, err error) *ErrorMessage {
+	return &ErrorMessage{
+		Envelope: &Envelope{
+			Type:     MESSAGE_TYPE_ERROR,
+			ClientId: clientId,
+		},
+		Code:  ErrorCodeOf(err),
+		Error: err.Error(),
+	}
+}
+
+// NewAckMessage creates the message acknowledging the successful execution
+// of the client message with the given client ID
+func NewAckMessage(clientId string) *AckMessage {
+	return &AckMessage{
+		Envelope: &Envelope{
+			Type:     MESSAGE_TYPE_ACK,
+			ClientId: clientId,
+		},
+	}
+}
+
+// ParseClientId returns the client ID of a message, if any,
+// even if the message is not a valid command
+func ParseClientId(message []byte) string {
+	var envelope Envelope
+	if err := json.Unmarshal(message, &envelope); err != nil {
+		return ""
+	}
+	return envelope.ClientId
+}
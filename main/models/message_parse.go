@@ -2,7 +2,6 @@ package models
 
 import (
 	"encoding/json"
-	"fmt"
 	"log"
 )
 
@@ -15,6 +14,10 @@ func createCommand(envelope Envelope) (Command, error) {
 		return &UserConnectMessage{}, nil
 	case MESSAGE_TYPE_USER_DISCONNECTED:
 		return &UserDisconnectMessage{}, nil
+	case MESSAGE_TYPE_RESUME:
+		return &ResumeMessage{}, nil
+	case MESSAGE_TYPE_REFRESH_AUTH:
+		return &RefreshAuthMessage{}, nil
 	case MESSAGE_TYPE_QUIZ_MESSAGE:
 		if envelope.Action == QUIZ_MESSAGE_ACTION_START {
 			return &QuizStartMessage{}, nil
@@ -30,25 +33,30 @@ func createCommand(envelope Envelope) (Command, error) {
 			return &QuizQuestionEndMessage{}, nil
 		} else if envelope.Action == QUIZ_MESSAGE_ACTION_NEXT_QUESTION {
 			return &QuizNextQuestionMessage{}, nil
+		} else if envelope.Action == QUIZ_MESSAGE_ACTION_LEADERBOARD {
+			return &QuizLeaderboardMessage{}, nil
 		} else {
-			return nil, fmt.Errorf("unknown quiz message action: %d", envelope.Action)
+			return nil, newCommandError(ERROR_CODE_UNKNOWN_QUIZ_ACTION, "unknown quiz message action: %d", envelope.Action)
 		}
 	default:
-		return nil, fmt.Errorf("unknown message type: %d", envelope.Type)
+		return nil, newCommandError(ERROR_CODE_UNKNOWN_MESSAGE_TYPE, "unknown message type: %d", envelope.Type)
 	}
 }
 
 // ParseCommand checks if the message represents a JsonMessage.
 func ParseCommand(message []byte) (*Command, error) {
-	messageStr := string(message)
-	log.Printf("Parsing message: %s\n", messageStr)
-
 	// Try to parse as JSON
 	var envelope Envelope
 	err := json.Unmarshal(message, &envelope)
 	if err != nil {
 		log.Printf("Error parsing message as JSON: %v\n", err)
-		return nil, err
+		return nil, &CommandError{Code: ERROR_CODE_INVALID_MESSAGE, Err: err}
+	}
+	if envelope.Type == MESSAGE_TYPE_REFRESH_AUTH {
+		// never log tokens
+		log.Printf("Parsing refresh auth message\n")
+	} else {
+		log.Printf("Parsing message: %s\n", string(message))
 	}
 
 	// Create the appropriate Action based on the envelope type
@@ -61,7 +69,7 @@ func ParseCommand(message []byte) (*Command, error) {
 	// Unmarshal the full message into the command
 	if err := json.Unmarshal(message, command); err != nil {
 		log.Printf("Error unmarshaling message: %v\n", err)
-		return nil, err
+		return nil, &CommandError{Code: ERROR_CODE_INVALID_MESSAGE, Err: err}
 	}
 
 	return &command, nil
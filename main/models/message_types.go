@@ -8,6 +8,11 @@ const (
 	MESSAGE_TYPE_MESSAGE           MessageType = 2
 	MESSAGE_TYPE_NOTIFICATION      MessageType = 3
 	MESSAGE_TYPE_QUIZ_MESSAGE      MessageType = 4
+	MESSAGE_TYPE_ERROR             MessageType = 5
+	MESSAGE_TYPE_ACK               MessageType = 6
+	MESSAGE_TYPE_RESUME            MessageType = 7
+	MESSAGE_TYPE_SESSION_SNAPSHOT  MessageType = 8
+	MESSAGE_TYPE_REFRESH_AUTH      MessageType = 9
 )
 
 // RecipientType represents the type of entity (session or login)
@@ -22,6 +27,8 @@ const (
 type Recipient struct {
 	Type RecipientType `json:"type"`
 	Id   string        `json:"id"`
+	// Name is the name displayed for a learner, set by the server only
+	Name string `json:"name,omitempty"`
 }
 
 // JsonMessage represents a JSON message with sender, recipients and content
@@ -32,9 +39,17 @@ type Envelope struct {
 	ClientId  string            `json:"clientId,omitempty"`
 }
 
+// Addressable is implemented by messages carrying their own recipients list
+type Addressable interface {
+	Recipients() []Recipient
+}
+
+// UserConnectMessage is sent by the server when a user connects.
+// From is always set by the server, a client can only choose its nickname.
 type UserConnectMessage struct {
-	From Recipient   `json:"from"`
-	To   []Recipient `json:"to"`
+	From     Recipient   `json:"from"`
+	To       []Recipient `json:"to"`
+	Nickname string      `json:"nickname,omitempty"`
 	*Envelope
 }
 
@@ -51,6 +66,70 @@ type Message struct {
 	*Envelope
 }
 
+// ErrorMessage is sent by the server to the client whose command failed,
+// ClientId echoes the client ID of the failed message
+type ErrorMessage struct {
+	*Envelope
+	Code  ErrorCode `json:"code"`
+	Error string    `json:"error"`
+}
+
+// AckMessage is sent by the server to the client whose command succeeded,
+// ClientId echoes the client ID of the acknowledged message
+type AckMessage struct {
+	*Envelope
+}
+
+// ResumeMessage is sent by a reconnecting client with the sequence number
+// of the last frame it received, to get the frames it missed
+type ResumeMessage struct {
+	*Envelope
+	LastSeq uint64 `json:"lastSeq"`
+}
+
+// RefreshAuthMessage is sent by a client with a new token of the same user
+// before its current token expires, to keep its connection open
+type RefreshAuthMessage struct {
+	*Envelope
+	Token string `json:"token"`
+}
+
+// SessionSnapshotMessage is sent by the server to a newly connected client,
+// and to a resuming client when the frames it missed are not available anymore
+type SessionSnapshotMessage struct {
+	*Envelope
+	SessionID string        `json:"sessionId"`
+	State     SessionState  `json:"state"`
+	LastSeq   uint64        `json:"lastSeq"`
+	Users     []Recipient   `json:"users"`
+	Quiz      *QuizSnapshot `json:"quiz,omitempty"`
+}
+
+// QuizSnapshot is the state of the running quiz sent to a late joiner
+type QuizSnapshot struct {
+	CurrentQuestion *QuizQuestionMessage `json:"currentQuestion"`
+	Status          QuestionStatus       `json:"status"`
+	// RemainingReadingTime is the number of seconds before the answers open
+	RemainingReadingTime int `json:"remainingReadingTime"`
+	// RemainingTime is the number of seconds before the question timeout,
+	// zero if the question has no timeout
+	RemainingTime int `json:"remainingTime"`
+	// Answered is true if the learner already answered the current question
+	Answered bool `json:"answered"`
+}
+
+func (msg UserConnectMessage) Recipients() []Recipient {
+	return msg.To
+}
+
+func (msg UserDisconnectMessage) Recipients() []Recipient {
+	return msg.To
+}
+
+func (msg Message) Recipients() []Recipient {
+	return msg.To
+}
+
 // QuizMessageAction defines the possible actions for quiz messages
 type QuizMessageAction int
 
@@ -63,6 +142,7 @@ const (
 	QUIZ_MESSAGE_ACTION_NEXT_QUESTION
 	QUIZ_MESSAGE_ACTION_STATS
 	QUIZ_MESSAGE_ACTION_LEARNER_ANSWER_FREE_TEXT
+	QUIZ_MESSAGE_ACTION_LEADERBOARD
 )
 
 // QuizQuestionStatus defines the possible statuses of a quiz question
@@ -112,7 +192,11 @@ type QuizQuestionMessage struct {
 	QuestionType   QuestionType `json:"questionType"`
 	QuestionNumber int          `json:"questionNumber"`
 	QuestionCount  int          `json:"questionCount"`
-	Timeout        int          `json:"timeout"`
+	// ReadingTime is the number of seconds before the answers open
+	ReadingTime int `json:"readingTime"`
+	// Timeout is the number of seconds given to answer once the answers are
+	// open, the question has no timeout if zero
+	Timeout int `json:"timeout"`
 }
 
 type QuestionStatus int
@@ -138,6 +222,9 @@ type QuizQuestionStatsMessage struct {
 	AnsweredCount        int                  `json:"answeredCount"`
 	AnswersStats         map[int]AnswerStat   `json:"answersStats"`
 	FreeTextAnswersStats []FreeTextAnswerStat `json:"freeTextAnswersStats"`
+	// AverageCredit is the average fractional score of the answers to a
+	// multiple choice question
+	AverageCredit *float64 `json:"averageCredit,omitempty"`
 }
 
 type QuizQuestionEndMessage struct {
@@ -161,4 +248,22 @@ type QuizStatsMessage struct {
 	QuizId        int                   `json:"quizId"`
 	LearnersCount int                   `json:"learnersCount"`
 	PlayerStats   map[string]PlayerStat `json:"playerStats"`
+	Leaderboard   []LeaderboardEntry    `json:"leaderboard"`
+}
+
+// QuizLeaderboardMessage is sent by the server when a question ends, to the
+// session with the top of the leaderboard, then to each ranked learner with
+// its own rank
+type QuizLeaderboardMessage struct {
+	*Envelope
+	QuizID        int                `json:"quizId"`
+	QuestionID    int                `json:"questionId"`
+	LearnersCount int                `json:"learnersCount"`
+	Top           []LeaderboardEntry `json:"top,omitempty"`
+	Rank          *LeaderboardEntry  `json:"rank,omitempty"`
+	To            []Recipient        `json:"to,omitempty"`
+}
+
+func (msg QuizLeaderboardMessage) Recipients() []Recipient {
+	return msg.To
 }
@@ -20,6 +20,11 @@ type FreeTextAnswerStat struct {
 type QuestionPlayerStat struct {
 	PlayerLogin string        `json:"playerLogin"`
 	Correct     AnswerCorrect `json:"correct"`
+	// Credit is the fractional score of the answer, between 0 and 1
+	Credit float64 `json:"credit"`
+	// Points depend on the credit and on how fast the learner answered
+	Points      int `json:"points"`
+	StreakBonus int `json:"streakBonus"`
 }
 
 type QuestionStats struct {
@@ -34,10 +39,18 @@ type PlayerStat struct {
 	PlayerLogin   string `json:"playerLogin"`
 	CountAnswered int    `json:"countAnswered"`
 	CountCorrect  int    `json:"countCorrect"`
+	// Credit is the sum of the fractional scores of the answers
+	Credit float64 `json:"credit"`
+	Score  int     `json:"score"`
+	// Streak is the number of consecutive questions answered correctly
+	Streak int `json:"streak"`
+	// userID routes the messages of the learner
+	userID string
 }
 
 type QuizGame struct {
 	SessionID                string
+	InstanceName             string
 	quiz                     *Quiz
 	questionStats            map[int]QuestionStats
 	playerStats              map[string]PlayerStat
@@ -49,9 +62,29 @@ type QuizGame struct {
 
 	// timeout management
 	currentQuizQuestion *Question
+	// questionOpen is true until the current question times out or ends
+	questionOpen        bool
 	questionTimer       *time.Timer
-	questionTimeout     time.Duration
-	commandServices     CommandServices
+	questionReadingTime time.Duration
+	// questionTimeout is the answering time of the current question, which
+	// has no timeout if zero
+	questionTimeout time.Duration
+	// defaultQuestionTimeout applies to the questions whose timeout is set
+	// neither by the question nor by the quiz
+	defaultQuestionTimeout time.Duration
+	questionGeneration     int
+	commandServices        CommandServices
+
+	// leaderboardPending is true when a question ended and its leaderboard
+	// is not sent yet
+	leaderboardPending bool
+	// leaderboardQuestionID is the ID of the question the pending
+	// leaderboard follows
+	leaderboardQuestionID int
+
+	// dispatch sends timer events to the goroutine owning the quiz game,
+	// events are handled synchronously when nil
+	dispatch func(func())
 }
 
 // Quiz represents a complete quiz with questions
@@ -61,6 +94,20 @@ type Quiz struct {
 	Title     string     `json:"title"`
 	URL       string     `json:"url"`
 	Questions []Question `json:"questions"`
+	// Timeout is the number of seconds given to answer each question,
+	// DEFAULT_TIMEOUT_SECONDS if not set. With a zero timeout the questions
+	// never time out, the facilitator moves to the next question.
+	Timeout *int `json:"timeout,omitempty"`
+	// ReadingTime is the number of seconds each question is shown before
+	// its answers open, none if not set
+	ReadingTime *int `json:"readingTime,omitempty"`
+	// LeaderboardSize is the number of learners of the leaderboard sent
+	// after each question, DEFAULT_LEADERBOARD_SIZE if not set, no
+	// leaderboard is sent if zero
+	LeaderboardSize *int `json:"leaderboardSize,omitempty"`
+	// Scoring is the scoring mode of the questions with several correct
+	// answers, SCORING_MODE_ALL_OR_NOTHING if not set
+	Scoring ScoringMode `json:"scoring,omitempty"`
 }
 
 // Question represents a single quiz question
@@ -70,7 +117,11 @@ type Question struct {
 	QuestionType QuestionType `json:"questionType"`
 	URL          string       `json:"url"`
 	Answers      []Answer     `json:"answers"`
-	startedAt    time.Time
+	// Timeout, ReadingTime and Scoring override the ones of the quiz if set
+	Timeout     *int        `json:"timeout,omitempty"`
+	ReadingTime *int        `json:"readingTime,omitempty"`
+	Scoring     ScoringMode `json:"scoring,omitempty"`
+	startedAt   time.Time
 }
 
 type AnswerCorrect int
@@ -89,13 +140,17 @@ type Answer struct {
 	Correct AnswerCorrect `json:"correct,omitempty"`
 }
 
-// ParseQuiz parses a JSON string into a Quiz struct
+// ParseQuiz parses a JSON string into a Quiz struct and validates it, see
+// Quiz.Validate
 func ParseQuiz(jsonData string) (*Quiz, error) {
 	var quiz Quiz
 	err := json.Unmarshal([]byte(jsonData), &quiz)
 	if err != nil {
 		return nil, fmt.Errorf("error parsing quiz JSON: %v", err)
 	}
+	if err := quiz.Validate(); err != nil {
+		return nil, err
+	}
 	return &quiz, nil
 }
 
@@ -117,6 +172,16 @@ func (q *Question) Clone() Question {
 	return clone
 }
 
+// CloneWithoutCorrectAnswers returns a clone of the question
+// that can be sent to learners
+func (q *Question) CloneWithoutCorrectAnswers() Question {
+	clone := q.Clone()
+	for i := range clone.Answers {
+		clone.Answers[i].Correct = ANSWER_CORRECT_UNKNOWN
+	}
+	return clone
+}
+
 func (a *Answer) Clone() Answer {
 	return *a
 }
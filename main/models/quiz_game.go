@@ -1,7 +1,6 @@
 package models
 
 import (
-	"fmt"
 	"log"
 	"time"
 )
@@ -9,9 +8,7 @@ import (
 const DEFAULT_TIMEOUT_SECONDS = 30
 
 func (quizGame *QuizGame) Start(quiz *Quiz, user *User) {
-	if quizGame.questionTimer != nil {
-		quizGame.questionTimer.Stop()
-	}
+	quizGame.Stop()
 	quizGame.quiz = quiz
 	quizGame.questionStats = make(map[int]QuestionStats)
 	quizGame.playerStats = make(map[string]PlayerStat)
@@ -20,6 +17,46 @@ func (quizGame *QuizGame) Start(quiz *Quiz, user *User) {
 	quizGame.currentQuestionIndex = -1
 }
 
+// Stop closes the current question and stops its timer, if any
+func (quizGame *QuizGame) Stop() {
+	quizGame.questionOpen = false
+	if quizGame.questionTimer != nil {
+		log.Printf("Stopping timer of session %s\n", quizGame.SessionID)
+		quizGame.questionTimer.Stop()
+		quizGame.questionTimer = nil
+	}
+}
+
+// questionTiming returns the reading time and the timeout of the question,
+// set by the question, else by the quiz. The question has no timeout if the
+// returned timeout is zero.
+func (quizGame *QuizGame) questionTiming(question *Question) (readingTime time.Duration, timeout time.Duration) {
+	timeout = quizGame.defaultQuestionTimeout
+	if question.Timeout != nil {
+		timeout = time.Duration(*question.Timeout) * time.Second
+	} else if quizGame.quiz.Timeout != nil {
+		timeout = time.Duration(*quizGame.quiz.Timeout) * time.Second
+	}
+	if question.ReadingTime != nil {
+		readingTime = time.Duration(*question.ReadingTime) * time.Second
+	} else if quizGame.quiz.ReadingTime != nil {
+		readingTime = time.Duration(*quizGame.quiz.ReadingTime) * time.Second
+	}
+	return readingTime, timeout
+}
+
+// seconds converts a duration to seconds, rounded up so that learners are
+// never told a delay is over too early
+func seconds(duration time.Duration) int {
+	return int((duration + time.Second - 1) / time.Second)
+}
+
+// answersOpen returns true once the reading time of the current question
+// is over
+func (quizGame *QuizGame) answersOpen(now time.Time) bool {
+	return !now.Before(quizGame.currentQuizQuestion.startedAt.Add(quizGame.questionReadingTime))
+}
+
 // contains checks if a slice contains a specific element
 func contains(slice []int, element int) bool {
 	for _, v := range slice {
@@ -45,6 +82,10 @@ func (quizGame *QuizGame) NextQuizQuestionMessage() any {
 	if previousQuestion != nil {
 		previousQuestionId = previousQuestion.ID
 	}
+	if quizGame.questionOpen {
+		log.Printf("Closing question %d\n", previousQuestionId)
+		quizGame.endQuestion()
+	}
 	question := quizGame.getNextQuestion()
 	if question == nil {
 		return &QuizStatsMessage{
@@ -55,57 +96,35 @@ func (quizGame *QuizGame) NextQuizQuestionMessage() any {
 			LearnersCount: len(quizGame.playerStats),
 			QuizId:        quizGame.quiz.ID,
 			PlayerStats:   quizGame.playerStats,
+			Leaderboard:   quizGame.Leaderboard(),
 		}
 	}
-	if quizGame.questionTimer != nil {
-		log.Printf("Stopping timer for question %d\n", previousQuestionId)
-		quizGame.questionTimer.Stop()
-	}
 	questionClone := question.Clone()
 	questionClone.startedAt = time.Now()
 	quizGame.currentQuizQuestion = &questionClone
+	quizGame.questionReadingTime, quizGame.questionTimeout = quizGame.questionTiming(question)
+	quizGame.questionOpen = true
+	quizGame.questionGeneration++
+	if quizGame.questionTimeout == 0 {
+		log.Printf("Question %d has no timeout\n", question.ID)
+		return quizGame.newQuizQuestionMessage()
+	}
 
-	// create timer
+	// create timer, its expiration is handled as an event of the quiz game owner
 	log.Printf("Starting timer for question %d\n", question.ID)
-	quizGame.questionTimer = time.AfterFunc(quizGame.questionTimeout, func() {
-		log.Printf("Question %d Timed out after %d.\n", question.ID, quizGame.questionTimeout)
-		quizGame.questionTimer = nil
-		var quizQuestionStatsMessage *QuizQuestionStatsMessage = nil
-		var err error
-		if question.QuestionType == QUESTION_TYPE_MCQ {
-			quizQuestionStatsMessage, err = quizGame.AnswerMCQuestion(question.ID, []int{}, &User{Login: "system"})
-		} else if question.QuestionType == QUESTION_TYPE_FREE_TEXT {
-			quizQuestionStatsMessage, err = quizGame.AnswerFreeTextQuestion(question.ID, []string{}, &User{Login: "system"})
-		}
-		if err != nil {
-			log.Printf("Error processing timeout: %v\n", err)
-		}
-		questionStats := quizGame.GetQuestionStatsOrCreate(question.ID)
-		for _, answer := range question.Answers {
-			answerStat := questionStats.GetAnswerStatsOrCreate(question.ID, answer.ID)
-			answerStat.Correct = answer.Correct
-			quizQuestionStatsMessage.AnswersStats[answer.ID] = *answerStat
-		}
-		if err != nil {
-			log.Printf("Error processing timeout: %v\n", err)
-		}
-		if quizQuestionStatsMessage != nil {
-			err := quizGame.commandServices.MessageSender(
-				&User{Login: "system", SessionID: quizGame.SessionID},
-				quizQuestionStatsMessage,
-			)
-			if err != nil {
-				log.Printf("Error sending timeout message: %v\n", err)
-			}
-		}
+	generation := quizGame.questionGeneration
+	quizGame.questionTimer = time.AfterFunc(quizGame.questionReadingTime+quizGame.questionTimeout, func() {
+		quizGame.dispatchEvent(func() {
+			quizGame.handleQuestionTimeout(generation)
+		})
 	})
 
-	// remove correct answers from the question
-	for i := range questionClone.Answers {
-		answer := &questionClone.Answers[i]
-		answer.Correct = ANSWER_CORRECT_UNKNOWN
-	}
+	return quizGame.newQuizQuestionMessage()
+}
 
+// newQuizQuestionMessage creates the message of the current question,
+// without its correct answers
+func (quizGame *QuizGame) newQuizQuestionMessage() *QuizQuestionMessage {
 	return &QuizQuestionMessage{
 		Envelope: &Envelope{
 			Type:   MESSAGE_TYPE_QUIZ_MESSAGE,
@@ -118,13 +137,95 @@ func (quizGame *QuizGame) NextQuizQuestionMessage() any {
 			URL:       quizGame.quiz.URL,
 			StartedBy: quizGame.StartedBy,
 		},
-		Question:       questionClone,
+		Question:       quizGame.currentQuizQuestion.CloneWithoutCorrectAnswers(),
 		QuestionNumber: quizGame.currentQuestionIndex + 1,
 		QuestionCount:  len(quizGame.quiz.Questions),
-		Timeout:        DEFAULT_TIMEOUT_SECONDS,
+		ReadingTime:    seconds(quizGame.questionReadingTime),
+		Timeout:        seconds(quizGame.questionTimeout),
+	}
+}
+
+// Snapshot returns the state of the running quiz as seen by the given
+// learner, nil if no question has been asked yet or the quiz ended
+func (quizGame *QuizGame) Snapshot(user *User, now time.Time) *QuizSnapshot {
+	if quizGame.quiz == nil || quizGame.currentQuizQuestion == nil ||
+		quizGame.currentQuestionIndex < 0 || quizGame.currentQuestionIndex >= len(quizGame.quiz.Questions) {
+		return nil
+	}
+	question := quizGame.currentQuizQuestion
+	snapshot := &QuizSnapshot{
+		CurrentQuestion: quizGame.newQuizQuestionMessage(),
+		Status:          QUESTION_STATUS_IN_PROGRESS,
+	}
+	if questionStats, ok := quizGame.questionStats[question.ID]; ok {
+		snapshot.Status = questionStats.QuestionStatus
+		playerStat, answered := questionStats.PlayerStats[user.Login]
+		snapshot.Answered = answered && playerStat.Correct != ANSWER_CORRECT_UNKNOWN
+	}
+	if quizGame.questionOpen {
+		elapsed := now.Sub(question.startedAt)
+		if remaining := quizGame.questionReadingTime - elapsed; remaining > 0 {
+			snapshot.RemainingReadingTime = seconds(remaining)
+		}
+		if quizGame.questionTimeout > 0 {
+			if remaining := quizGame.questionReadingTime + quizGame.questionTimeout - elapsed; remaining > 0 {
+				snapshot.RemainingTime = seconds(remaining)
+			}
+		}
+	}
+	return snapshot
+}
+
+func (quizGame *QuizGame) dispatchEvent(fn func()) {
+	if quizGame.dispatch == nil {
+		fn()
+		return
+	}
+	quizGame.dispatch(fn)
+}
+
+// handleQuestionTimeout ends the question started at the given generation
+// and sends the final question stats to the session.
+// Timeouts of questions that already ended are ignored.
+func (quizGame *QuizGame) handleQuestionTimeout(generation int) {
+	quizQuestionStatsMessage := quizGame.timeoutQuestion(generation)
+	if quizQuestionStatsMessage == nil {
+		return
+	}
+	system := &User{Login: "system", SessionID: quizGame.SessionID, InstanceName: quizGame.InstanceName}
+	err := quizGame.commandServices.MessageSender(system, quizQuestionStatsMessage)
+	if err != nil {
+		log.Printf("Error sending timeout message: %v\n", err)
+	}
+	for _, message := range quizGame.TakeLeaderboardMessages() {
+		if err := quizGame.commandServices.MessageSender(system, message); err != nil {
+			log.Printf("Error sending leaderboard message: %v\n", err)
+		}
 	}
 }
 
+// timeoutQuestion marks the question started at the given generation as
+// timed out and returns its final stats, including the correct answers.
+func (quizGame *QuizGame) timeoutQuestion(generation int) *QuizQuestionStatsMessage {
+	if generation != quizGame.questionGeneration || !quizGame.questionOpen {
+		return nil
+	}
+	quizGame.questionTimer = nil
+	quizGame.endQuestion()
+	question := quizGame.quiz.Questions[quizGame.currentQuestionIndex]
+	log.Printf("Question %d Timed out after %v.\n", question.ID, quizGame.questionTimeout)
+
+	questionStats := quizGame.GetQuestionStatsOrCreate(question.ID)
+	questionStats.QuestionStatus = QUESTION_STATUS_TIMEOUT
+	for _, answer := range question.Answers {
+		answerStat := questionStats.GetAnswerStatsOrCreate(question.ID, answer.ID)
+		answerStat.Correct = answer.Correct
+		questionStats.AnswersStats[answer.ID] = *answerStat
+	}
+	quizGame.questionStats[question.ID] = *questionStats
+	return quizGame.getQuizQuestionStatsMessage(question.ID, QUIZ_MESSAGE_ACTION_QUESTION_END)
+}
+
 func (quizGame *QuizGame) GetQuestionStatsOrCreate(questionId int) *QuestionStats {
 	stats, ok := quizGame.questionStats[questionId]
 	if !ok {
@@ -156,33 +257,40 @@ func (quizGame *QuizGame) AnswerMCQuestion(
 	questionId int, answers []int, user *User,
 ) (*QuizQuestionStatsMessage, error) {
 	if quizGame.quiz == nil {
-		return nil, fmt.Errorf("quiz not started")
+		return nil, newCommandError(ERROR_CODE_QUIZ_NOT_STARTED, "quiz not started")
 	}
 	if quizGame.currentQuestionIndex < 0 {
-		return nil, fmt.Errorf("quiz not started")
+		return nil, newCommandError(ERROR_CODE_QUIZ_NOT_STARTED, "quiz not started")
 	}
 	if quizGame.currentQuestionIndex >= len(quizGame.quiz.Questions) {
-		return nil, fmt.Errorf("quiz ended")
+		return nil, newCommandError(ERROR_CODE_QUIZ_ENDED, "quiz ended")
 	}
 	question := quizGame.quiz.Questions[quizGame.currentQuestionIndex]
 	if question.ID != questionId {
-		return nil, fmt.Errorf("question ID %d does not match current question ID %d", questionId, question.ID)
+		return nil, newCommandError(
+			ERROR_CODE_QUESTION_MISMATCH,
+			"question ID %d does not match current question ID %d", questionId, question.ID,
+		)
 	}
 	if question.QuestionType != QUESTION_TYPE_MCQ {
-		return nil, fmt.Errorf("question ID %d is not a multiple choice question", questionId)
+		return nil, newCommandError(ERROR_CODE_WRONG_QUESTION_TYPE, "question ID %d is not a multiple choice question", questionId)
 	}
 	questionStats := quizGame.GetQuestionStatsOrCreate(questionId)
-	if quizGame.questionTimer == nil {
+	if !quizGame.questionOpen {
 		// robustness: in normal cases the server would
 		// have already sent a timeout message
 		questionStats.QuestionStatus = QUESTION_STATUS_TIMEOUT
 		quizGame.questionStats[questionId] = *questionStats
 		return quizGame.getQuizQuestionStatsMessage(questionId, QUIZ_MESSAGE_ACTION_QUESTION_END), nil
 	}
+	now := time.Now()
+	if !quizGame.answersOpen(now) {
+		return nil, newCommandError(ERROR_CODE_ANSWERS_NOT_OPEN, "answers to question %d are not open yet", questionId)
+	}
 	questionPlayerStats, ok := questionStats.PlayerStats[user.Login]
 	if ok {
 		if questionPlayerStats.Correct != ANSWER_CORRECT_UNKNOWN {
-			return nil, fmt.Errorf("user %s already answered question %d", user.Login, questionId)
+			return nil, newCommandError(ERROR_CODE_ALREADY_ANSWERED, "user %s already answered question %d", user.Login, questionId)
 		}
 	} else {
 		questionPlayerStats = QuestionPlayerStat{
@@ -198,35 +306,27 @@ func (quizGame *QuizGame) AnswerMCQuestion(
 	}
 	for _, answerId := range answers {
 		if !contains(validAnswerIds, answerId) {
-			return nil, fmt.Errorf("answer ID %d is invalid", answerId)
+			return nil, newCommandError(ERROR_CODE_INVALID_ANSWER, "answer ID %d is invalid", answerId)
 		}
 	}
 
-	// check if answers are correct
-	questionAnsweredCorrectly := true
+	// count the answers
 	for _, answer := range question.Answers {
 		answerStat := questionStats.GetAnswerStatsOrCreate(questionId, answer.ID)
 		answerStat.Correct = answer.Correct
-
-		// check if answerId is in answers
 		if contains(answers, answer.ID) {
 			answerStat.Count++
-			if answer.Correct != ANSWER_CORRECT_CORRECT {
-				questionAnsweredCorrectly = false
-			}
-		} else {
-			if answer.Correct != ANSWER_CORRECT_INCORRECT {
-				questionAnsweredCorrectly = false
-			}
 		}
 		questionStats.AnswersStats[answer.ID] = *answerStat
 	}
+
+	// check if answers are correct
+	questionPlayerStats.Credit = answerCredit(&question, answers, quizGame.scoringMode(&question))
+	questionAnsweredCorrectly := questionPlayerStats.Credit == 1
+	questionPlayerStats.Correct = ANSWER_CORRECT_INCORRECT
 	if questionAnsweredCorrectly {
-		if questionAnsweredCorrectly {
-			questionPlayerStats.Correct = ANSWER_CORRECT_CORRECT
-		}
+		questionPlayerStats.Correct = ANSWER_CORRECT_CORRECT
 	}
-	questionStats.PlayerStats[user.Login] = questionPlayerStats
 
 	// consolidate player stats
 	playerStat, ok := quizGame.playerStats[user.Login]
@@ -238,10 +338,14 @@ func (quizGame *QuizGame) AnswerMCQuestion(
 		}
 	}
 	playerStat.PlayerLogin = user.Login
+	playerStat.userID = user.UserID
 	playerStat.CountAnswered++
 	if questionAnsweredCorrectly {
 		playerStat.CountCorrect += 1
 	}
+	playerStat.Credit += questionPlayerStats.Credit
+	quizGame.scoreAnswer(&questionPlayerStats, &playerStat, now)
+	questionStats.PlayerStats[user.Login] = questionPlayerStats
 	quizGame.playerStats[user.Login] = playerStat
 
 	// end of quiz if all players have answered
@@ -250,16 +354,10 @@ func (quizGame *QuizGame) AnswerMCQuestion(
 	answeredCount := len(questionStats.PlayerStats)
 	questionStatus := QUESTION_STATUS_IN_PROGRESS
 	if playersCount == answeredCount {
-		// reset the timer
-		if quizGame.questionTimer != nil {
-			log.Printf("Stopping timer for question %d\n", questionId)
-			quizGame.questionTimer.Stop()
-		}
 		// all players have answered
 		questionStatus = QUESTION_STATUS_ENDED
 		action = QUIZ_MESSAGE_ACTION_QUESTION_END
-		quizGame.questionTimer.Stop()
-		quizGame.questionTimer = nil
+		quizGame.endQuestion()
 	}
 	questionStats.QuestionStatus = questionStatus
 	quizGame.questionStats[questionId] = *questionStats
@@ -272,34 +370,40 @@ func (quizGame *QuizGame) AnswerFreeTextQuestion(
 	questionId int, answers []string, user *User,
 ) (*QuizQuestionStatsMessage, error) {
 	if quizGame.quiz == nil {
-		return nil, fmt.Errorf("quiz not started")
+		return nil, newCommandError(ERROR_CODE_QUIZ_NOT_STARTED, "quiz not started")
 	}
 	if quizGame.currentQuestionIndex < 0 {
-		return nil, fmt.Errorf("quiz not started")
+		return nil, newCommandError(ERROR_CODE_QUIZ_NOT_STARTED, "quiz not started")
 	}
 	if quizGame.currentQuestionIndex >= len(quizGame.quiz.Questions) {
-		return nil, fmt.Errorf("quiz ended")
+		return nil, newCommandError(ERROR_CODE_QUIZ_ENDED, "quiz ended")
 	}
 	question := quizGame.quiz.Questions[quizGame.currentQuestionIndex]
 	if question.ID != questionId {
-		return nil, fmt.Errorf("question ID %d does not match current question ID %d", questionId, question.ID)
+		return nil, newCommandError(
+			ERROR_CODE_QUESTION_MISMATCH,
+			"question ID %d does not match current question ID %d", questionId, question.ID,
+		)
 	}
 	if question.QuestionType != QUESTION_TYPE_FREE_TEXT {
-		return nil, fmt.Errorf("question ID %d is not a free text question", questionId)
+		return nil, newCommandError(ERROR_CODE_WRONG_QUESTION_TYPE, "question ID %d is not a free text question", questionId)
 	}
 
 	questionStats := quizGame.GetQuestionStatsOrCreate(questionId)
-	if quizGame.questionTimer == nil {
+	if !quizGame.questionOpen {
 		// robustness: in normal cases the server would
 		// have already sent a timeout message
 		questionStats.QuestionStatus = QUESTION_STATUS_TIMEOUT
 		quizGame.questionStats[questionId] = *questionStats
 		return quizGame.getQuizQuestionStatsMessage(questionId, QUIZ_MESSAGE_ACTION_QUESTION_END), nil
 	}
+	if !quizGame.answersOpen(time.Now()) {
+		return nil, newCommandError(ERROR_CODE_ANSWERS_NOT_OPEN, "answers to question %d are not open yet", questionId)
+	}
 	questionPlayerStats, ok := questionStats.PlayerStats[user.Login]
 	if ok {
 		if questionPlayerStats.Correct != ANSWER_CORRECT_UNKNOWN {
-			return nil, fmt.Errorf("user %s already answered question %d", user.Login, questionId)
+			return nil, newCommandError(ERROR_CODE_ALREADY_ANSWERED, "user %s already answered question %d", user.Login, questionId)
 		}
 	} else {
 		questionPlayerStats = QuestionPlayerStat{
@@ -308,6 +412,10 @@ func (quizGame *QuizGame) AnswerFreeTextQuestion(
 		}
 	}
 
+	if len(answers) == 0 {
+		return nil, newCommandError(ERROR_CODE_INVALID_ANSWER, "answer to question %d is empty", questionId)
+	}
+
 	// check if answers are correct
 	questionPlayerStats.Correct = ANSWER_CORRECT_CORRECT
 	questionStats.PlayerStats[user.Login] = questionPlayerStats
@@ -326,6 +434,7 @@ func (quizGame *QuizGame) AnswerFreeTextQuestion(
 		}
 	}
 	playerStat.PlayerLogin = user.Login
+	playerStat.userID = user.UserID
 	playerStat.CountAnswered++
 	quizGame.playerStats[user.Login] = playerStat
 
@@ -338,8 +447,7 @@ func (quizGame *QuizGame) AnswerFreeTextQuestion(
 		// all players have answered
 		questionStatus = QUESTION_STATUS_ENDED
 		action = QUIZ_MESSAGE_ACTION_QUESTION_END
-		quizGame.questionTimer.Stop()
-		quizGame.questionTimer = nil
+		quizGame.endQuestion()
 	}
 	questionStats.QuestionStatus = questionStatus
 	quizGame.questionStats[questionId] = *questionStats
@@ -366,5 +474,27 @@ func (quizGame *QuizGame) getQuizQuestionStatsMessage(questionId int, action Qui
 		AnsweredCount:        len(questionStats.PlayerStats),
 		AnswersStats:         questionStats.AnswersStats,
 		FreeTextAnswersStats: questionStats.FreeTextAnswersStats,
+		AverageCredit:        quizGame.averageCredit(questionId, &questionStats),
+	}
+}
+
+// averageCredit returns the average credit of the answers to a multiple
+// choice question, nil if the question is not one or has no answer
+func (quizGame *QuizGame) averageCredit(questionId int, questionStats *QuestionStats) *float64 {
+	question := quizGame.quiz.GetQuestionByID(questionId)
+	if question == nil || question.QuestionType != QUESTION_TYPE_MCQ {
+		return nil
+	}
+	count, credit := 0, 0.0
+	for _, playerStat := range questionStats.PlayerStats {
+		if playerStat.Correct != ANSWER_CORRECT_UNKNOWN {
+			count++
+			credit += playerStat.Credit
+		}
+	}
+	if count == 0 {
+		return nil
 	}
+	average := credit / float64(count)
+	return &average
 }
@@ -10,10 +10,10 @@ import (
 func newQuizGame() *QuizGame {
 	quiz, _ := ParseQuiz(validQuizJSON)
 	quizGame := &QuizGame{
-		quiz:            quiz,
-		StartedBy:       "badLogin",
-		StartedAt:       time.Unix(0, 0),
-		questionTimeout: 30 * time.Minute,
+		quiz:                   quiz,
+		StartedBy:              "badLogin",
+		StartedAt:              time.Unix(0, 0),
+		defaultQuestionTimeout: 30 * time.Minute,
 		GetConnectedPlayersCount: func() int {
 			return 2
 		},
@@ -105,7 +105,7 @@ func TestQuizGameNextQuizQuestion(t *testing.T) {
 		if err != nil {
 			t.Errorf("Error marshaling JsonMessage: %v\n", err)
 		}
-		expected := `{"type":4,"action":1,"quizInfo":{"id":1,"title":"Test Quiz","type":0,"url":"/quiz/1","startedBy":"login"},"question":{"id":101,"question":"What is Go?","questionType":0,"url":"/question/101","answers":[{"id":1001,"title":"A programming language","url":"/answer/1001","correct":-1},{"id":1002,"title":"A board game","url":"/answer/1002","correct":-1}]},"questionType":0,"questionNumber":1,"questionCount":2,"timeout":30}`
+		expected := `{"type":4,"action":1,"quizInfo":{"id":1,"title":"Test Quiz","type":0,"url":"/quiz/1","startedBy":"login"},"question":{"id":101,"question":"What is Go?","questionType":0,"url":"/question/101","answers":[{"id":1001,"title":"A programming language","url":"/answer/1001","correct":-1},{"id":1002,"title":"A board game","url":"/answer/1002","correct":-1}]},"questionType":0,"questionNumber":1,"questionCount":2,"readingTime":0,"timeout":1800}`
 		if !bytes.Equal(msgStr, []byte(expected)) {
 			t.Errorf("Expected message to be %s, got %s", expected, msgStr)
 		}
@@ -121,7 +121,7 @@ func TestQuizGameNextQuizQuestion(t *testing.T) {
 		if err != nil {
 			t.Errorf("Error marshaling JsonMessage: %v\n", err)
 		}
-		expected := `{"type":4,"action":1,"quizInfo":{"id":1,"title":"Test Quiz","type":0,"url":"/quiz/1","startedBy":"login"},"question":{"id":102,"question":"What year was Go released?","questionType":0,"url":"/question/102","answers":[{"id":1003,"title":"2007","url":"/answer/1003","correct":-1},{"id":1004,"title":"2009","url":"/answer/1004","correct":-1}]},"questionType":0,"questionNumber":2,"questionCount":2,"timeout":30}`
+		expected := `{"type":4,"action":1,"quizInfo":{"id":1,"title":"Test Quiz","type":0,"url":"/quiz/1","startedBy":"login"},"question":{"id":102,"question":"What year was Go released?","questionType":0,"url":"/question/102","answers":[{"id":1003,"title":"2007","url":"/answer/1003","correct":-1},{"id":1004,"title":"2009","url":"/answer/1004","correct":-1}]},"questionType":0,"questionNumber":2,"questionCount":2,"readingTime":0,"timeout":1800}`
 		if !bytes.Equal(msgStr, []byte(expected)) {
 			t.Errorf("Expected message to be %s, got %s", expected, msgStr)
 		}
@@ -134,7 +134,7 @@ func TestQuizGameNextQuizQuestion(t *testing.T) {
 		if err != nil {
 			t.Errorf("Error marshaling JsonMessage: %v\n", err)
 		}
-		expected := `{"type":4,"action":6,"quizId":1,"learnersCount":0,"playerStats":{}}`
+		expected := `{"type":4,"action":6,"quizId":1,"learnersCount":0,"playerStats":{},"leaderboard":[]}`
 		if !bytes.Equal(msgStr, []byte(expected)) {
 			t.Errorf("Expected message to be %s, got %s", expected, msgStr)
 		}
@@ -202,7 +202,7 @@ func TestQuizGameAnsweringQuestion(t *testing.T) {
 		if err != nil {
 			t.Errorf("Error marshaling JsonMessage: %v\n", err)
 		}
-		expected := `{"type":4,"action":3,"questionId":101,"status":0,"learnersCount":2,"answeredCount":1,"answersStats":{"1001":{"answerId":1001,"count":1,"correct":1},"1002":{"answerId":1002,"count":0,"correct":0}},"freeTextAnswersStats":[]}`
+		expected := `{"type":4,"action":3,"questionId":101,"status":0,"learnersCount":2,"answeredCount":1,"answersStats":{"1001":{"answerId":1001,"count":1,"correct":1},"1002":{"answerId":1002,"count":0,"correct":0}},"freeTextAnswersStats":[],"averageCredit":1}`
 		if !bytes.Equal(msgStr, []byte(expected)) {
 			t.Errorf("Expected message to be %s, got %s", expected, msgStr)
 		}
@@ -237,7 +237,7 @@ func TestQuizGameAnsweringQuestion(t *testing.T) {
 		if err != nil {
 			t.Errorf("Error marshaling JsonMessage: %v\n", err)
 		}
-		expected := `{"type":4,"action":4,"questionId":101,"status":2,"learnersCount":2,"answeredCount":2,"answersStats":{"1001":{"answerId":1001,"count":1,"correct":1},"1002":{"answerId":1002,"count":1,"correct":0}},"freeTextAnswersStats":[]}`
+		expected := `{"type":4,"action":4,"questionId":101,"status":2,"learnersCount":2,"answeredCount":2,"answersStats":{"1001":{"answerId":1001,"count":1,"correct":1},"1002":{"answerId":1002,"count":1,"correct":0}},"freeTextAnswersStats":[],"averageCredit":0.5}`
 		if !bytes.Equal(msgStr, []byte(expected)) {
 			t.Errorf("Expected message to be %s, got %s", expected, msgStr)
 		}
@@ -248,7 +248,7 @@ func TestQuizGameAnsweringQuestion(t *testing.T) {
 
 	t.Run("Next question - try to answer but timeout (robustness)", func(t *testing.T) {
 		quizGame.NextQuizQuestionMessage()
-		quizGame.questionTimer = nil // simulate timeout
+		quizGame.questionOpen = false // simulate timeout
 		stats, err := quizGame.AnswerMCQuestion(102, []int{1003}, &User{Login: "login1"})
 		if err != nil {
 			t.Errorf("Expected no error, got %v", err)
@@ -277,7 +277,7 @@ func TestQuizGameAnsweringQuestion(t *testing.T) {
 		if err != nil {
 			t.Errorf("Error marshaling JsonMessage: %v\n", err)
 		}
-		expected := `{"type":4,"action":6,"quizId":1,"learnersCount":2,"playerStats":{"login1":{"playerLogin":"login1","countAnswered":1,"countCorrect":1},"login2":{"playerLogin":"login2","countAnswered":1,"countCorrect":0}}}`
+		expected := `{"type":4,"action":6,"quizId":1,"learnersCount":2,"playerStats":{"login1":{"playerLogin":"login1","countAnswered":1,"countCorrect":1,"credit":1,"score":1000,"streak":1},"login2":{"playerLogin":"login2","countAnswered":1,"countCorrect":0,"credit":0,"score":0,"streak":0}},"leaderboard":[{"rank":1,"playerLogin":"login1","score":1000,"countCorrect":1,"streak":1},{"rank":2,"playerLogin":"login2","score":0,"countCorrect":0,"streak":0}]}`
 		if !bytes.Equal(msgStr, []byte(expected)) {
 			t.Errorf("Expected message to be %s, got %s", expected, msgStr)
 		}
@@ -286,3 +286,158 @@ func TestQuizGameAnsweringQuestion(t *testing.T) {
 		}
 	})
 }
+
+func TestQuizGameSnapshot(t *testing.T) {
+	quizGame := newQuizGame()
+	learner := &User{Login: "login1"}
+
+	t.Run("No question asked yet", func(t *testing.T) {
+		if snapshot := quizGame.Snapshot(learner, time.Now()); snapshot != nil {
+			t.Errorf("Expected no snapshot, got %+v", snapshot)
+		}
+	})
+
+	quizGame.NextQuizQuestionMessage()
+	defer quizGame.Stop()
+	startedAt := quizGame.currentQuizQuestion.startedAt
+
+	t.Run("Question in progress", func(t *testing.T) {
+		snapshot := quizGame.Snapshot(learner, startedAt.Add(10*time.Second+time.Millisecond))
+		if snapshot == nil {
+			t.Fatal("Expected snapshot, got nil")
+		}
+		msgStr, err := json.Marshal(snapshot)
+		if err != nil {
+			t.Errorf("Error marshaling JsonMessage: %v\n", err)
+		}
+		expected := `{"currentQuestion":{"type":4,"action":1,"quizInfo":{"id":1,"title":"Test Quiz","type":0,"url":"/quiz/1","startedBy":"login"},"question":{"id":101,"question":"What is Go?","questionType":0,"url":"/question/101","answers":[{"id":1001,"title":"A programming language","url":"/answer/1001","correct":-1},{"id":1002,"title":"A board game","url":"/answer/1002","correct":-1}]},"questionType":0,"questionNumber":1,"questionCount":2,"readingTime":0,"timeout":1800},"status":0,"remainingReadingTime":0,"remainingTime":1790,"answered":false}`
+		if !bytes.Equal(msgStr, []byte(expected)) {
+			t.Errorf("Expected message to be %s, got %s", expected, msgStr)
+		}
+	})
+
+	t.Run("Question answered by the learner", func(t *testing.T) {
+		_, err := quizGame.AnswerMCQuestion(101, []int{1001}, learner)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		snapshot := quizGame.Snapshot(learner, startedAt)
+		if !snapshot.Answered {
+			t.Error("Expected learner to have answered")
+		}
+		if quizGame.Snapshot(&User{Login: "login2"}, startedAt).Answered {
+			t.Error("Expected other learner not to have answered")
+		}
+	})
+
+	t.Run("Question timed out", func(t *testing.T) {
+		quizGame.timeoutQuestion(quizGame.questionGeneration)
+		snapshot := quizGame.Snapshot(learner, startedAt)
+		if snapshot.Status != QUESTION_STATUS_TIMEOUT {
+			t.Errorf("Expected timeout status, got %d", snapshot.Status)
+		}
+		if snapshot.RemainingTime != 0 {
+			t.Errorf("Expected no remaining time, got %d", snapshot.RemainingTime)
+		}
+	})
+}
+
+func TestQuizGameQuestionTiming(t *testing.T) {
+	intPtr := func(value int) *int { return &value }
+	tests := []struct {
+		name            string
+		quizTimeout     *int
+		quizReading     *int
+		questionTimeout *int
+		questionReading *int
+		wantTimeout     int
+		wantReading     int
+	}{
+		{name: "Default timeout", wantTimeout: DEFAULT_TIMEOUT_SECONDS},
+		{name: "Quiz timeout", quizTimeout: intPtr(10), quizReading: intPtr(5), wantTimeout: 10, wantReading: 5},
+		{
+			name: "Question override", quizTimeout: intPtr(10), quizReading: intPtr(5),
+			questionTimeout: intPtr(180), questionReading: intPtr(0), wantTimeout: 180,
+		},
+		{name: "No timeout", quizTimeout: intPtr(0), wantTimeout: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quiz, _ := ParseQuiz(validQuizJSON)
+			quiz.Timeout, quiz.ReadingTime = tt.quizTimeout, tt.quizReading
+			quiz.Questions[0].Timeout, quiz.Questions[0].ReadingTime = tt.questionTimeout, tt.questionReading
+			quizGame := &QuizGame{defaultQuestionTimeout: DEFAULT_TIMEOUT_SECONDS * time.Second}
+			quizGame.Start(quiz, &User{Login: "facilitator"})
+			defer quizGame.Stop()
+
+			message := quizGame.NextQuizQuestionMessage().(*QuizQuestionMessage)
+			if message.Timeout != tt.wantTimeout || message.ReadingTime != tt.wantReading {
+				t.Errorf("Expected timeout %d and reading time %d, got %d and %d",
+					tt.wantTimeout, tt.wantReading, message.Timeout, message.ReadingTime)
+			}
+			// the advertised timing is the one of the server timer
+			wantTimer := time.Duration(tt.wantReading+tt.wantTimeout) * time.Second
+			if got := quizGame.questionReadingTime + quizGame.questionTimeout; tt.wantTimeout > 0 && got != wantTimer {
+				t.Errorf("Expected timer of %v, got %v", wantTimer, got)
+			}
+			if (quizGame.questionTimer != nil) != (tt.wantTimeout > 0) {
+				t.Errorf("Expected timer only if the question has a timeout, got %v", quizGame.questionTimer)
+			}
+		})
+	}
+}
+
+func TestQuizGameReadingTime(t *testing.T) {
+	quiz, _ := ParseQuiz(validQuizJSON)
+	readingTime, timeout := 5, 10
+	quiz.ReadingTime, quiz.Timeout = &readingTime, &timeout
+	quizGame := &QuizGame{GetConnectedPlayersCount: func() int { return 2 }}
+	quizGame.Start(quiz, &User{Login: "facilitator"})
+	quizGame.NextQuizQuestionMessage()
+	defer quizGame.Stop()
+	learner := &User{Login: "login1"}
+
+	_, err := quizGame.AnswerMCQuestion(101, []int{1001}, learner)
+	if code := ErrorCodeOf(err); code != ERROR_CODE_ANSWERS_NOT_OPEN {
+		t.Errorf("Expected error code %s, got %v", ERROR_CODE_ANSWERS_NOT_OPEN, err)
+	}
+	startedAt := quizGame.currentQuizQuestion.startedAt
+	snapshot := quizGame.Snapshot(learner, startedAt.Add(2*time.Second))
+	if snapshot.RemainingReadingTime != 3 || snapshot.RemainingTime != 13 {
+		t.Errorf("Expected 3s of reading and 13s before timeout, got %ds and %ds",
+			snapshot.RemainingReadingTime, snapshot.RemainingTime)
+	}
+
+	quizGame.currentQuizQuestion.startedAt = startedAt.Add(-5 * time.Second)
+	if _, err := quizGame.AnswerMCQuestion(101, []int{1001}, learner); err != nil {
+		t.Errorf("Expected answers to be open after the reading time, got %v", err)
+	}
+}
+
+func TestQuizGameWithoutTimeout(t *testing.T) {
+	quiz, _ := ParseQuiz(validQuizJSON)
+	noTimeout := 0
+	quiz.Timeout = &noTimeout
+	quizGame := &QuizGame{GetConnectedPlayersCount: func() int { return 2 }}
+	quizGame.Start(quiz, &User{Login: "facilitator"})
+	quizGame.NextQuizQuestionMessage()
+	learner := &User{Login: "login1"}
+
+	snapshot := quizGame.Snapshot(learner, time.Now().Add(time.Hour))
+	if snapshot.Status != QUESTION_STATUS_IN_PROGRESS || snapshot.RemainingTime != 0 {
+		t.Errorf("Expected question to stay in progress without remaining time, got %+v", snapshot)
+	}
+	if _, err := quizGame.AnswerMCQuestion(101, []int{1001}, learner); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// the facilitator moves to the next question before everyone answered
+	message := quizGame.NextQuizQuestionMessage().(*QuizQuestionMessage)
+	if message.Question.ID != 102 || message.Timeout != 0 {
+		t.Errorf("Expected question 102 without timeout, got %d with timeout %d", message.Question.ID, message.Timeout)
+	}
+	stats, err := quizGame.AnswerMCQuestion(102, []int{1004}, learner)
+	if err != nil || stats.Status != QUESTION_STATUS_IN_PROGRESS {
+		t.Errorf("Expected answer to be accepted, got %+v, %v", stats, err)
+	}
+}
@@ -0,0 +1,196 @@
+package models
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// quizFile is a quiz file of the repository directory as last read
+type quizFile struct {
+	modTime time.Time
+	size    int64
+	// quiz is the last valid quiz of the file, nil if it never was valid
+	quiz *Quiz
+	err  error
+}
+
+// QuizRepository holds the quizzes of the JSON files of a directory, indexed
+// by quiz ID. The quizzes it returns are shared and must not be modified,
+// they are cloned when a quiz starts.
+type QuizRepository struct {
+	dir string
+
+	// quizzes is replaced as a whole on each reload
+	quizzes    map[int]*Quiz
+	quizzesMux sync.RWMutex
+
+	// files and reloads are owned by Reload
+	files     map[string]*quizFile
+	reloadMux sync.Mutex
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewQuizRepository loads the quizzes of the directory. Invalid files are
+// reported and skipped, an error is only returned if the directory cannot
+// be read.
+func NewQuizRepository(dir string) (*QuizRepository, error) {
+	repository := &QuizRepository{
+		dir:     dir,
+		quizzes: make(map[int]*Quiz),
+		files:   make(map[string]*quizFile),
+		stop:    make(chan struct{}),
+	}
+	if _, err := repository.Reload(); err != nil {
+		return nil, err
+	}
+	return repository, nil
+}
+
+// GetQuiz returns the quiz with the given ID
+func (repository *QuizRepository) GetQuiz(quizID int) (*Quiz, error) {
+	repository.quizzesMux.RLock()
+	defer repository.quizzesMux.RUnlock()
+	quiz, ok := repository.quizzes[quizID]
+	if !ok {
+		return nil, fmt.Errorf("unknown quiz ID: %d", quizID)
+	}
+	return quiz, nil
+}
+
+// Quizzes returns the loaded quizzes sorted by ID
+func (repository *QuizRepository) Quizzes() []*Quiz {
+	repository.quizzesMux.RLock()
+	defer repository.quizzesMux.RUnlock()
+	quizzes := make([]*Quiz, 0, len(repository.quizzes))
+	for _, quiz := range repository.quizzes {
+		quizzes = append(quizzes, quiz)
+	}
+	slices.SortFunc(quizzes, func(a, b *Quiz) int { return a.ID - b.ID })
+	return quizzes
+}
+
+// Reload reads the files of the directory that changed since the last
+// reload and replaces the quizzes at once. A file that became invalid keeps
+// its last valid quiz. It returns the errors of the invalid files, which
+// are logged when the files are read.
+func (repository *QuizRepository) Reload() (map[string]error, error) {
+	repository.reloadMux.Lock()
+	defer repository.reloadMux.Unlock()
+
+	if _, err := os.Stat(repository.dir); err != nil {
+		return nil, fmt.Errorf("error reading quiz directory %s: %w", repository.dir, err)
+	}
+	paths, err := filepath.Glob(filepath.Join(repository.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	changed := false
+	for path := range repository.files {
+		if !slices.Contains(paths, path) {
+			log.Printf("Quiz file %s removed", path)
+			delete(repository.files, path)
+			changed = true
+		}
+	}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			// removed since the glob, forgotten on the next reload
+			continue
+		}
+		file, known := repository.files[path]
+		if known && file.modTime.Equal(info.ModTime()) && file.size == info.Size() {
+			continue
+		}
+		if !known {
+			file = &quizFile{}
+			repository.files[path] = file
+		}
+		file.modTime, file.size = info.ModTime(), info.Size()
+		changed = true
+
+		quiz, err := loadQuizFile(path)
+		file.err = err
+		if err != nil {
+			if file.quiz != nil {
+				log.Printf("Invalid quiz file %s, keeping its previous version: %v", path, err)
+			} else {
+				log.Printf("Invalid quiz file %s: %v", path, err)
+			}
+			continue
+		}
+		file.quiz = quiz
+		log.Printf("Loaded quiz %d %q with %d questions from %s", quiz.ID, quiz.Title, len(quiz.Questions), path)
+	}
+
+	// files are indexed in name order, the first file using a quiz ID wins
+	errs := make(map[string]error)
+	quizzes := make(map[int]*Quiz)
+	for _, path := range paths {
+		file, ok := repository.files[path]
+		if !ok {
+			continue
+		}
+		if file.err != nil {
+			errs[path] = file.err
+		}
+		if file.quiz == nil {
+			continue
+		}
+		if other, ok := quizzes[file.quiz.ID]; ok {
+			errs[path] = fmt.Errorf("quiz ID %d is already used by quiz %q", file.quiz.ID, other.Title)
+			if changed {
+				log.Printf("Invalid quiz file %s: %v", path, errs[path])
+			}
+			continue
+		}
+		quizzes[file.quiz.ID] = file.quiz
+	}
+
+	if changed {
+		repository.quizzesMux.Lock()
+		repository.quizzes = quizzes
+		repository.quizzesMux.Unlock()
+	}
+	return errs, nil
+}
+
+// loadQuizFile reads and parses a quiz file
+func loadQuizFile(path string) (*Quiz, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseQuiz(string(content))
+}
+
+// Watch reloads the quizzes every interval until Close is called
+func (repository *QuizRepository) Watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := repository.Reload(); err != nil {
+				log.Printf("Error reloading quizzes: %v", err)
+			}
+		case <-repository.stop:
+			return
+		}
+	}
+}
+
+// Close stops watching the directory
+func (repository *QuizRepository) Close() {
+	repository.stopOnce.Do(func() {
+		close(repository.stop)
+	})
+}
@@ -0,0 +1,188 @@
+package models
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeQuizFile writes a quiz file whose modification time differs from the
+// previous version of the file
+func writeQuizFile(t *testing.T, dir string, name string, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	previous := time.Now().Add(-time.Hour)
+	if info, err := os.Stat(path); err == nil {
+		previous = info.ModTime()
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+	if err := os.Chtimes(path, previous.Add(time.Second), previous.Add(time.Second)); err != nil {
+		t.Fatalf("Failed to touch %s: %v", path, err)
+	}
+	return path
+}
+
+func TestQuizRepository(t *testing.T) {
+	dir := t.TempDir()
+	writeQuizFile(t, dir, "1.json", validQuizJSON)
+	writeQuizFile(t, dir, "2.json", strings.Replace(validQuizJSON, `"id": 1,`, `"id": 2,`, 1))
+	brokenPath := writeQuizFile(t, dir, "broken.json", invalidQuizJSON)
+	duplicatePath := writeQuizFile(t, dir, "duplicate.json", validQuizJSON)
+	writeQuizFile(t, dir, "notes.txt", "not a quiz")
+
+	repository, err := NewQuizRepository(dir)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if quizzes := repository.Quizzes(); len(quizzes) != 2 || quizzes[0].ID != 1 || quizzes[1].ID != 2 {
+		t.Fatalf("Expected quizzes 1 and 2 to be loaded, got %d quizzes", len(quizzes))
+	}
+	errs, err := repository.Reload()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(errs) != 2 || errs[brokenPath] == nil || errs[duplicatePath] == nil {
+		t.Errorf("Expected broken and duplicate files to be reported, got %v", errs)
+	}
+	if _, err := repository.GetQuiz(3); err == nil {
+		t.Error("Expected unknown quiz to be an error")
+	}
+
+	tests := []struct {
+		name    string
+		update  func()
+		quizID  int
+		title   string
+		wantErr bool
+	}{
+		{
+			name:   "Edited quiz is reloaded",
+			update: func() { writeQuizFile(t, dir, "1.json", strings.Replace(validQuizJSON, "Test Quiz", "Edited Quiz", 1)) },
+			quizID: 1,
+			title:  "Edited Quiz",
+		},
+		{
+			name:   "Invalid edit keeps the previous version",
+			update: func() { writeQuizFile(t, dir, "1.json", invalidQuizJSON) },
+			quizID: 1,
+			title:  "Edited Quiz",
+		},
+		{
+			name: "Fixed file is reloaded",
+			update: func() {
+				writeQuizFile(t, dir, "broken.json", strings.Replace(validQuizJSON, `"id": 1,`, `"id": 3,`, 1))
+			},
+			quizID: 3,
+			title:  "Test Quiz",
+		},
+		{
+			name: "Removed file is forgotten",
+			update: func() {
+				if err := os.Remove(filepath.Join(dir, "2.json")); err != nil {
+					t.Fatalf("Failed to remove file: %v", err)
+				}
+			},
+			quizID:  2,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.update()
+			if _, err := repository.Reload(); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			quiz, err := repository.GetQuiz(tt.quizID)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected quiz %d to be unknown", tt.quizID)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if quiz.Title != tt.title {
+				t.Errorf("Expected title %q, got %q", tt.title, quiz.Title)
+			}
+		})
+	}
+}
+
+func TestQuizRepositoryStartedQuizIsNotReloaded(t *testing.T) {
+	dir := t.TempDir()
+	writeQuizFile(t, dir, "1.json", validQuizJSON)
+	repository, err := NewQuizRepository(dir)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	quiz, _ := repository.GetQuiz(1)
+	started := quiz.Clone()
+
+	writeQuizFile(t, dir, "1.json", strings.Replace(validQuizJSON, "What is Go?", "What is Rust?", 1))
+	if _, err := repository.Reload(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if reloaded, _ := repository.GetQuiz(1); reloaded.Questions[0].Question != "What is Rust?" {
+		t.Errorf("Expected quiz to be reloaded, got %q", reloaded.Questions[0].Question)
+	}
+	if started.Questions[0].Question != "What is Go?" {
+		t.Errorf("Expected started quiz to be unchanged, got %q", started.Questions[0].Question)
+	}
+}
+
+func TestQuizRepositoryRemovedQuizKeepsRunning(t *testing.T) {
+	dir := t.TempDir()
+	path := writeQuizFile(t, dir, "1.json", validQuizJSON)
+	repository, err := NewQuizRepository(dir)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	session := NewSession(SessionKey{SessionID: "1"})
+	t.Cleanup(session.Close)
+	session.PlayerJoined()
+	services := (&recordingServices{timeouts: make(chan *QuizQuestionStatsMessage, 10)}).commandServices()
+	services.GetQuiz = func(instanceName string, quizId int) (*Quiz, error) {
+		return repository.GetQuiz(quizId)
+	}
+	facilitator := &User{Login: "facilitator", SessionID: "1"}
+	execute := func(command Command) error {
+		var err error
+		if doErr := session.Do(func() { err = command.Execute(facilitator, session, services) }); doErr != nil {
+			return doErr
+		}
+		return err
+	}
+
+	if err := execute(&QuizStartMessage{QuizId: 1}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("Failed to remove file: %v", err)
+	}
+	if _, err := repository.Reload(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := repository.GetQuiz(1); err == nil {
+		t.Fatal("Expected removed quiz to be unknown")
+	}
+
+	if err := execute(&QuizNextQuestionMessage{QuizId: 1}); err != nil {
+		t.Fatalf("Expected the running game to advance, got %v", err)
+	}
+	var questionID int
+	session.Do(func() { questionID = session.QuizGame.currentQuizQuestion.ID })
+	if questionID != 102 {
+		t.Errorf("Expected question 102, got %d", questionID)
+	}
+}
+
+func TestNewQuizRepositoryMissingDirectory(t *testing.T) {
+	if _, err := NewQuizRepository(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("Expected missing directory to be an error")
+	}
+}
@@ -0,0 +1,244 @@
+package models
+
+import (
+	"cmp"
+	"math"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ScoringMode defines how the answers to a multiple choice question with
+// several correct answers are credited
+type ScoringMode string
+
+const (
+	// SCORING_MODE_ALL_OR_NOTHING credits the answers selecting exactly the
+	// correct answers
+	SCORING_MODE_ALL_OR_NOTHING ScoringMode = "allOrNothing"
+	// SCORING_MODE_PROPORTIONAL credits the ratio of the answers selected if
+	// correct or left if incorrect
+	SCORING_MODE_PROPORTIONAL ScoringMode = "proportional"
+	// SCORING_MODE_RIGHT_MINUS_WRONG credits the ratio of the correct answers
+	// selected, minus one correct answer for each incorrect one selected
+	SCORING_MODE_RIGHT_MINUS_WRONG ScoringMode = "rightMinusWrong"
+)
+
+const (
+	// SCORE_MAX_POINTS is the number of points of a correct answer given as
+	// soon as the answers open, half of it being given at the timeout
+	SCORE_MAX_POINTS = 1000
+	// SCORE_STREAK_BONUS is the bonus of each consecutive correct answer
+	// after the first one
+	SCORE_STREAK_BONUS = 100
+	// SCORE_MAX_STREAK_BONUS caps the streak bonus of an answer
+	SCORE_MAX_STREAK_BONUS = 500
+	// DEFAULT_LEADERBOARD_SIZE is the number of learners of the leaderboard
+	// sent to the session after each question
+	DEFAULT_LEADERBOARD_SIZE = 10
+)
+
+// LeaderboardEntry is the rank of a learner in the quiz, learners with the
+// same score sharing the same rank
+type LeaderboardEntry struct {
+	Rank         int    `json:"rank"`
+	PlayerLogin  string `json:"playerLogin"`
+	Score        int    `json:"score"`
+	CountCorrect int    `json:"countCorrect"`
+	Streak       int    `json:"streak"`
+}
+
+// answerPoints returns the points of a correct answer given after elapsed
+// since the answers opened, decreasing linearly with the answering time.
+// Answers of questions without timeout are not weighted by speed.
+func answerPoints(elapsed time.Duration, timeout time.Duration) int {
+	if timeout <= 0 {
+		return SCORE_MAX_POINTS
+	}
+	ratio := min(max(float64(elapsed)/float64(timeout), 0), 1)
+	return int(math.Round(SCORE_MAX_POINTS * (1 - ratio/2)))
+}
+
+// streakBonus returns the bonus of a correct answer extending the streak of
+// the learner to the given length
+func streakBonus(streak int) int {
+	return min((streak-1)*SCORE_STREAK_BONUS, SCORE_MAX_STREAK_BONUS)
+}
+
+// scoringMode returns the scoring mode of the question, set by the question,
+// else by the quiz
+func (quizGame *QuizGame) scoringMode(question *Question) ScoringMode {
+	if question.Scoring != "" {
+		return question.Scoring
+	}
+	if quizGame.quiz.Scoring != "" {
+		return quizGame.quiz.Scoring
+	}
+	return SCORING_MODE_ALL_OR_NOTHING
+}
+
+// answerCredit returns the credit of the given answers to a multiple choice
+// question, between 0 and 1. Questions with a single correct answer are
+// scored all or nothing whatever the scoring mode.
+func answerCredit(question *Question, answers []int, mode ScoringMode) float64 {
+	countCorrect, countIncorrect := 0, 0
+	selectedCorrect, selectedIncorrect := 0, 0
+	for _, answer := range question.Answers {
+		selected := contains(answers, answer.ID)
+		if answer.Correct == ANSWER_CORRECT_CORRECT {
+			countCorrect++
+			if selected {
+				selectedCorrect++
+			}
+		} else {
+			countIncorrect++
+			if selected {
+				selectedIncorrect++
+			}
+		}
+	}
+	if countCorrect == 0 {
+		return 0
+	}
+	if countCorrect == 1 {
+		mode = SCORING_MODE_ALL_OR_NOTHING
+	}
+
+	switch mode {
+	case SCORING_MODE_PROPORTIONAL:
+		// an answer selecting no correct option gets nothing, not even for
+		// the incorrect options it leaves
+		if selectedCorrect == 0 {
+			return 0
+		}
+		// each option counts, selected if correct or left if incorrect
+		return float64(selectedCorrect+countIncorrect-selectedIncorrect) / float64(countCorrect+countIncorrect)
+	case SCORING_MODE_RIGHT_MINUS_WRONG:
+		return max(float64(selectedCorrect-selectedIncorrect)/float64(countCorrect), 0)
+	default:
+		if selectedCorrect == countCorrect && selectedIncorrect == 0 {
+			return 1
+		}
+		return 0
+	}
+}
+
+// scoreAnswer updates the stats of a learner whose answer to the current
+// question got the credit of questionPlayerStat at the given time. Only
+// fully correct answers extend the streak of the learner.
+func (quizGame *QuizGame) scoreAnswer(questionPlayerStat *QuestionPlayerStat, playerStat *PlayerStat, now time.Time) {
+	if questionPlayerStat.Credit == 1 {
+		playerStat.Streak++
+		questionPlayerStat.StreakBonus = streakBonus(playerStat.Streak)
+	} else {
+		playerStat.Streak = 0
+	}
+	if questionPlayerStat.Credit > 0 {
+		answersOpenAt := quizGame.currentQuizQuestion.startedAt.Add(quizGame.questionReadingTime)
+		points := answerPoints(now.Sub(answersOpenAt), quizGame.questionTimeout)
+		questionPlayerStat.Points = int(math.Round(float64(points) * questionPlayerStat.Credit))
+	}
+	playerStat.Score += questionPlayerStat.Points + questionPlayerStat.StreakBonus
+}
+
+// endQuestion closes the current question, breaking the streak of the
+// learners who did not answer it, and schedules its leaderboard
+func (quizGame *QuizGame) endQuestion() {
+	quizGame.Stop()
+	question := quizGame.currentQuizQuestion
+	if question == nil {
+		return
+	}
+	if question.QuestionType == QUESTION_TYPE_MCQ {
+		answered := quizGame.questionStats[question.ID].PlayerStats
+		for login, playerStat := range quizGame.playerStats {
+			if _, ok := answered[login]; !ok {
+				playerStat.Streak = 0
+				quizGame.playerStats[login] = playerStat
+			}
+		}
+	}
+	quizGame.leaderboardPending = true
+	quizGame.leaderboardQuestionID = question.ID
+}
+
+// Leaderboard returns the learners who answered at least one question,
+// ranked by score
+func (quizGame *QuizGame) Leaderboard() []LeaderboardEntry {
+	leaderboard := make([]LeaderboardEntry, 0, len(quizGame.playerStats))
+	for _, playerStat := range quizGame.playerStats {
+		leaderboard = append(leaderboard, LeaderboardEntry{
+			PlayerLogin:  playerStat.PlayerLogin,
+			Score:        playerStat.Score,
+			CountCorrect: playerStat.CountCorrect,
+			Streak:       playerStat.Streak,
+		})
+	}
+	slices.SortFunc(leaderboard, func(a, b LeaderboardEntry) int {
+		return cmp.Or(cmp.Compare(b.Score, a.Score), strings.Compare(a.PlayerLogin, b.PlayerLogin))
+	})
+	for i := range leaderboard {
+		leaderboard[i].Rank = i + 1
+		if i > 0 && leaderboard[i].Score == leaderboard[i-1].Score {
+			leaderboard[i].Rank = leaderboard[i-1].Rank
+		}
+	}
+	return leaderboard
+}
+
+// leaderboardSize returns the number of learners of the leaderboard sent
+// to the session, none if zero
+func (quizGame *QuizGame) leaderboardSize() int {
+	if quizGame.quiz.LeaderboardSize != nil {
+		return *quizGame.quiz.LeaderboardSize
+	}
+	return DEFAULT_LEADERBOARD_SIZE
+}
+
+// TakeLeaderboardMessages returns the leaderboard messages of the question
+// that ended since the last call, if any: the top of the leaderboard sent to
+// the session, then the rank of each learner sent to that learner only
+func (quizGame *QuizGame) TakeLeaderboardMessages() []any {
+	if !quizGame.leaderboardPending {
+		return nil
+	}
+	quizGame.leaderboardPending = false
+	size := quizGame.leaderboardSize()
+	if size <= 0 {
+		return nil
+	}
+	leaderboard := quizGame.Leaderboard()
+	newMessage := func() *QuizLeaderboardMessage {
+		return &QuizLeaderboardMessage{
+			Envelope: &Envelope{
+				Type:   MESSAGE_TYPE_QUIZ_MESSAGE,
+				Action: QUIZ_MESSAGE_ACTION_LEADERBOARD,
+			},
+			QuizID:        quizGame.quiz.ID,
+			QuestionID:    quizGame.leaderboardQuestionID,
+			LearnersCount: len(leaderboard),
+		}
+	}
+
+	top := newMessage()
+	top.Top = leaderboard[:min(size, len(leaderboard))]
+	messages := []any{top}
+	for _, entry := range leaderboard {
+		rank := newMessage()
+		rank.Rank = &entry
+		rank.To = []Recipient{{Type: RECIPIENT_TYPE_LEARNER, Id: quizGame.playerStats[entry.PlayerLogin].userID}}
+		messages = append(messages, rank)
+	}
+	return messages
+}
+
+// sendLeaderboard sends the leaderboard messages of the question that ended,
+// if any
+func sendLeaderboard(user *User, session *Session, commandServices CommandServices) error {
+	for _, message := range session.QuizGame.TakeLeaderboardMessages() {
+		if err := commandServices.MessageSender(user, message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAnswerPoints(t *testing.T) {
+	tests := []struct {
+		name    string
+		elapsed time.Duration
+		timeout time.Duration
+		want    int
+	}{
+		{"Immediate answer", 0, 10 * time.Second, SCORE_MAX_POINTS},
+		{"Answer at half time", 5 * time.Second, 10 * time.Second, 750},
+		{"Answer at the timeout", 10 * time.Second, 10 * time.Second, 500},
+		{"Late answer", 11 * time.Second, 10 * time.Second, 500},
+		{"Answer before the answers open", -time.Second, 10 * time.Second, SCORE_MAX_POINTS},
+		{"Question without timeout", time.Hour, 0, SCORE_MAX_POINTS},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := answerPoints(tt.elapsed, tt.timeout); got != tt.want {
+				t.Errorf("Expected %d points, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestStreakBonus(t *testing.T) {
+	tests := []struct {
+		streak int
+		want   int
+	}{
+		{1, 0},
+		{2, SCORE_STREAK_BONUS},
+		{4, 3 * SCORE_STREAK_BONUS},
+		{20, SCORE_MAX_STREAK_BONUS},
+	}
+	for _, tt := range tests {
+		if got := streakBonus(tt.streak); got != tt.want {
+			t.Errorf("Expected bonus %d for a streak of %d, got %d", tt.want, tt.streak, got)
+		}
+	}
+}
+
+// answerAfter answers the current question as if elapsed passed since it
+// started
+func answerAfter(t *testing.T, quizGame *QuizGame, login string, answers []int, elapsed time.Duration) {
+	t.Helper()
+	quizGame.currentQuizQuestion.startedAt = time.Now().Add(-elapsed)
+	if _, err := quizGame.AnswerMCQuestion(quizGame.currentQuizQuestion.ID, answers, &User{Login: login, UserID: "id-" + login}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+}
+
+func TestQuizGameLeaderboard(t *testing.T) {
+	quiz, _ := ParseQuiz(validQuizJSON)
+	timeout, leaderboardSize := 10, 2
+	quiz.Timeout, quiz.LeaderboardSize = &timeout, &leaderboardSize
+	quizGame := &QuizGame{GetConnectedPlayersCount: func() int { return 3 }}
+	quizGame.Start(quiz, &User{Login: "facilitator"})
+	defer quizGame.Stop()
+
+	// question 101: alice is the fastest, carol is wrong
+	quizGame.NextQuizQuestionMessage()
+	answerAfter(t, quizGame, "alice", []int{1001}, 0)
+	answerAfter(t, quizGame, "bob", []int{1001}, 5*time.Second)
+	answerAfter(t, quizGame, "carol", []int{1002}, 0)
+	messages := quizGame.TakeLeaderboardMessages()
+	if len(messages) != 4 {
+		t.Fatalf("Expected the top and 3 private ranks, got %d messages", len(messages))
+	}
+	top := messages[0].(*QuizLeaderboardMessage)
+	if top.QuestionID != 101 || len(top.Top) != 2 || top.Top[0].PlayerLogin != "alice" || top.Top[1].PlayerLogin != "bob" {
+		t.Errorf("Expected alice and bob at the top, got %+v", top.Top)
+	}
+	if top.To != nil {
+		t.Errorf("Expected the top to be sent to the session, got %+v", top.To)
+	}
+	carol := messages[3].(*QuizLeaderboardMessage)
+	if carol.Rank == nil || carol.Rank.PlayerLogin != "carol" || carol.Rank.Rank != 3 ||
+		len(carol.To) != 1 || carol.To[0].Id != "id-carol" || carol.Top != nil {
+		t.Errorf("Expected carol to privately get rank 3, got %+v", carol)
+	}
+	if quizGame.TakeLeaderboardMessages() != nil {
+		t.Error("Expected the leaderboard to be sent once per question")
+	}
+
+	// question 102: the facilitator moves on before alice answers
+	quizGame.NextQuizQuestionMessage()
+	answerAfter(t, quizGame, "bob", []int{1004}, 0)
+	answerAfter(t, quizGame, "carol", []int{1004}, 0)
+	quizGame.NextQuizQuestionMessage()
+
+	expected := []LeaderboardEntry{
+		{Rank: 1, PlayerLogin: "bob", Score: 750 + SCORE_MAX_POINTS + SCORE_STREAK_BONUS, CountCorrect: 2, Streak: 2},
+		{Rank: 2, PlayerLogin: "alice", Score: SCORE_MAX_POINTS, CountCorrect: 1, Streak: 0},
+		{Rank: 2, PlayerLogin: "carol", Score: SCORE_MAX_POINTS, CountCorrect: 1, Streak: 1},
+	}
+	leaderboard := quizGame.Leaderboard()
+	if len(leaderboard) != len(expected) {
+		t.Fatalf("Expected %d entries, got %+v", len(expected), leaderboard)
+	}
+	for i, entry := range expected {
+		if leaderboard[i] != entry {
+			t.Errorf("Expected entry %d to be %+v, got %+v", i, entry, leaderboard[i])
+		}
+	}
+	messages = quizGame.TakeLeaderboardMessages()
+	if len(messages) != 4 {
+		t.Fatalf("Expected the leaderboard of the question closed by the facilitator, got %d messages", len(messages))
+	}
+	for _, message := range messages {
+		if questionID := message.(*QuizLeaderboardMessage).QuestionID; questionID != 102 {
+			t.Errorf("Expected the leaderboard of question 102, got question %d", questionID)
+		}
+	}
+}
+
+func TestQuizGameWithoutLeaderboard(t *testing.T) {
+	quiz, _ := ParseQuiz(validQuizJSON)
+	noLeaderboard := 0
+	quiz.LeaderboardSize = &noLeaderboard
+	quizGame := &QuizGame{GetConnectedPlayersCount: func() int { return 1 }}
+	quizGame.Start(quiz, &User{Login: "facilitator"})
+	quizGame.NextQuizQuestionMessage()
+	defer quizGame.Stop()
+
+	answerAfter(t, quizGame, "alice", []int{1001}, 0)
+	if messages := quizGame.TakeLeaderboardMessages(); messages != nil {
+		t.Errorf("Expected no leaderboard, got %d messages", len(messages))
+	}
+}
+
+func TestSessionLeaderboardAfterTimeout(t *testing.T) {
+	session, services := newTestSession(t, 2, 20*time.Millisecond)
+	err := session.Do(func() {
+		msg := &QuizLearnerAnswerMessage{QuestionId: 101, Answers: []int{1001}}
+		if err := msg.Execute(&User{Login: "alice", UserID: "id-alice", SessionID: "1"}, session, services.commandServices()); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	select {
+	case <-services.timeouts:
+	case <-time.After(time.Second):
+		t.Fatal("Expected question to time out")
+	}
+	// the timeout is handled by the session, the leaderboard follows the stats
+	if err := session.Do(func() {}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	services.mu.Lock()
+	defer services.mu.Unlock()
+	last := len(services.messages) - 3
+	if last < 0 {
+		t.Fatalf("Expected stats then leaderboard messages, got %d messages", len(services.messages))
+	}
+	if _, ok := services.messages[last].(*QuizQuestionStatsMessage); !ok {
+		t.Errorf("Expected timeout stats, got %T", services.messages[last])
+	}
+	top, ok := services.messages[last+1].(*QuizLeaderboardMessage)
+	if !ok || len(top.Top) != 1 || top.Top[0].PlayerLogin != "alice" {
+		t.Errorf("Expected leaderboard with alice, got %+v", services.messages[last+1])
+	}
+	if rank, ok := services.messages[last+2].(*QuizLeaderboardMessage); !ok || rank.Rank == nil || rank.To[0].Id != "id-alice" {
+		t.Errorf("Expected private rank of alice, got %+v", services.messages[last+2])
+	}
+}
+
+func TestAnswerCredit(t *testing.T) {
+	// 3 correct answers out of 5
+	multiSelect := &Question{QuestionType: QUESTION_TYPE_MCQ, Answers: []Answer{
+		{ID: 1, Correct: ANSWER_CORRECT_CORRECT},
+		{ID: 2, Correct: ANSWER_CORRECT_CORRECT},
+		{ID: 3, Correct: ANSWER_CORRECT_CORRECT},
+		{ID: 4, Correct: ANSWER_CORRECT_INCORRECT},
+		{ID: 5, Correct: ANSWER_CORRECT_INCORRECT},
+	}}
+	singleSelect := &Question{QuestionType: QUESTION_TYPE_MCQ, Answers: []Answer{
+		{ID: 1, Correct: ANSWER_CORRECT_CORRECT},
+		{ID: 2, Correct: ANSWER_CORRECT_INCORRECT},
+		{ID: 3, Correct: ANSWER_CORRECT_INCORRECT},
+	}}
+	tests := []struct {
+		name     string
+		question *Question
+		mode     ScoringMode
+		answers  []int
+		want     float64
+	}{
+		{"All or nothing, exact answers", multiSelect, SCORING_MODE_ALL_OR_NOTHING, []int{1, 2, 3}, 1},
+		{"All or nothing, missed answer", multiSelect, SCORING_MODE_ALL_OR_NOTHING, []int{1, 2}, 0},
+		{"All or nothing, extra answer", multiSelect, SCORING_MODE_ALL_OR_NOTHING, []int{1, 2, 3, 4}, 0},
+		{"Proportional, exact answers", multiSelect, SCORING_MODE_PROPORTIONAL, []int{1, 2, 3}, 1},
+		{"Proportional, missed answer", multiSelect, SCORING_MODE_PROPORTIONAL, []int{1, 2}, 0.8},
+		{"Proportional, missed and extra answers", multiSelect, SCORING_MODE_PROPORTIONAL, []int{1, 4}, 0.4},
+		{"Proportional, duplicate answers", multiSelect, SCORING_MODE_PROPORTIONAL, []int{1, 1, 2, 2}, 0.8},
+		{"Proportional, empty answer", multiSelect, SCORING_MODE_PROPORTIONAL, []int{}, 0},
+		{"Proportional, incorrect answer only", multiSelect, SCORING_MODE_PROPORTIONAL, []int{4}, 0},
+		{"Right minus wrong, missed answer", multiSelect, SCORING_MODE_RIGHT_MINUS_WRONG, []int{1, 2}, 2.0 / 3},
+		{"Right minus wrong, extra answer", multiSelect, SCORING_MODE_RIGHT_MINUS_WRONG, []int{1, 2, 3, 4}, 2.0 / 3},
+		{"Right minus wrong, more wrong than right", multiSelect, SCORING_MODE_RIGHT_MINUS_WRONG, []int{1, 4, 5}, 0},
+		{"Single answer question is all or nothing", singleSelect, SCORING_MODE_PROPORTIONAL, []int{2}, 0},
+		{"Single answer question, correct answer", singleSelect, SCORING_MODE_RIGHT_MINUS_WRONG, []int{1}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := answerCredit(tt.question, tt.answers, tt.mode); got != tt.want {
+				t.Errorf("Expected credit %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestQuizGamePartialCredit(t *testing.T) {
+	quiz, _ := ParseQuiz(validQuizJSON)
+	timeout := 10
+	quiz.Timeout, quiz.Scoring = &timeout, SCORING_MODE_PROPORTIONAL
+	// question 101 has 2 correct answers out of 4
+	quiz.Questions[0].Answers = append(quiz.Questions[0].Answers,
+		Answer{ID: 1005, Title: "A mascot", Correct: ANSWER_CORRECT_CORRECT},
+		Answer{ID: 1006, Title: "A river", Correct: ANSWER_CORRECT_INCORRECT},
+	)
+	quiz.Questions[1].Scoring = SCORING_MODE_RIGHT_MINUS_WRONG
+	quizGame := &QuizGame{GetConnectedPlayersCount: func() int { return 3 }}
+	quizGame.Start(quiz, &User{Login: "facilitator"})
+	defer quizGame.Stop()
+
+	quizGame.NextQuizQuestionMessage()
+	answerAfter(t, quizGame, "alice", []int{1001, 1005}, 0)
+	answerAfter(t, quizGame, "bob", []int{1001}, 0)
+	if _, err := quizGame.AnswerMCQuestion(101, []int{1001, 1005}, &User{Login: "bob"}); ErrorCodeOf(err) != ERROR_CODE_ALREADY_ANSWERED {
+		t.Errorf("Expected partially correct answer not to be answered again, got %v", err)
+	}
+	bob := quizGame.questionStats[101].PlayerStats["bob"]
+	if bob.Credit != 0.75 || bob.Correct != ANSWER_CORRECT_INCORRECT || bob.Points != 750 || bob.StreakBonus != 0 {
+		t.Errorf("Expected bob to get 3/4 of the points, got %+v", bob)
+	}
+	stats := quizGame.getQuizQuestionStatsMessage(101, QUIZ_MESSAGE_ACTION_QUESTION_END)
+	if stats.AverageCredit == nil || *stats.AverageCredit != 0.875 {
+		t.Errorf("Expected average credit 0.875, got %v", stats.AverageCredit)
+	}
+
+	// question 102 has a single correct answer, scored all or nothing
+	quizGame.NextQuizQuestionMessage()
+	answerAfter(t, quizGame, "bob", []int{1004}, 0)
+	playerStat := quizGame.playerStats["bob"]
+	if playerStat.Credit != 1.75 || playerStat.CountCorrect != 1 || playerStat.Score != 750+SCORE_MAX_POINTS {
+		t.Errorf("Expected bob to have a credit of 1.75, got %+v", playerStat)
+	}
+}
@@ -0,0 +1,153 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+// QuizProblem is a problem found in a quiz, located by the JSON path of the
+// faulty field
+type QuizProblem struct {
+	Path    string
+	Message string
+}
+
+func (problem QuizProblem) Error() string {
+	return problem.Path + ": " + problem.Message
+}
+
+// QuizValidationError lists all the problems found in a quiz
+type QuizValidationError struct {
+	Problems []QuizProblem
+}
+
+func (e *QuizValidationError) Error() string {
+	messages := make([]string, len(e.Problems))
+	for i, problem := range e.Problems {
+		messages[i] = problem.Error()
+	}
+	return fmt.Sprintf("invalid quiz, %d problem(s): %s", len(e.Problems), strings.Join(messages, "; "))
+}
+
+// quizValidator collects the problems of a quiz
+type quizValidator struct {
+	problems []QuizProblem
+}
+
+func (v *quizValidator) addf(path string, format string, args ...any) {
+	v.problems = append(v.problems, QuizProblem{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// Validate checks the content of the quiz and returns a *QuizValidationError
+// listing every problem, nil if there is none
+func (q *Quiz) Validate() error {
+	v := &quizValidator{}
+	if q.ID <= 0 {
+		v.addf("id", "must be a positive integer, got %d", q.ID)
+	}
+	if strings.TrimSpace(q.Title) == "" {
+		v.addf("title", "must not be empty")
+	}
+	if q.Type != QUIZ_TYPE_MCQ && q.Type != QUIZ_TYPE_FREE_TEXT {
+		v.addf("type", "unknown quiz type %d, expected %d (MCQ) or %d (free text)",
+			q.Type, QUIZ_TYPE_MCQ, QUIZ_TYPE_FREE_TEXT)
+	}
+	v.validateTiming("", q.Timeout, q.ReadingTime)
+	if q.LeaderboardSize != nil && *q.LeaderboardSize < 0 {
+		v.addf("leaderboardSize", "must be a number of learners, 0 for no leaderboard, got %d", *q.LeaderboardSize)
+	}
+	v.validateScoring("scoring", q.Scoring)
+	if len(q.Questions) == 0 {
+		v.addf("questions", "the quiz has no question")
+	}
+
+	questionIndexes := make(map[int]int)
+	for i, question := range q.Questions {
+		path := fmt.Sprintf("questions[%d]", i)
+		if question.ID <= 0 {
+			v.addf(path+".id", "must be a positive integer, got %d", question.ID)
+		} else if other, ok := questionIndexes[question.ID]; ok {
+			v.addf(path+".id", "duplicate question ID %d, already used by questions[%d]", question.ID, other)
+		} else {
+			questionIndexes[question.ID] = i
+		}
+		if strings.TrimSpace(question.Question) == "" {
+			v.addf(path+".question", "must not be empty")
+		}
+		v.validateTiming(path+".", question.Timeout, question.ReadingTime)
+		v.validateScoring(path+".scoring", question.Scoring)
+		v.validateAnswers(path, &question)
+	}
+
+	if len(v.problems) > 0 {
+		return &QuizValidationError{Problems: v.problems}
+	}
+	return nil
+}
+
+// validateTiming checks the timeout and reading time of a quiz or a question
+func (v *quizValidator) validateTiming(pathPrefix string, timeout *int, readingTime *int) {
+	if timeout != nil && *timeout < 0 {
+		v.addf(pathPrefix+"timeout", "must be a number of seconds, 0 for no timeout, got %d", *timeout)
+	}
+	if readingTime != nil && *readingTime < 0 {
+		v.addf(pathPrefix+"readingTime", "must be a number of seconds, got %d", *readingTime)
+	}
+}
+
+// validateScoring checks the scoring mode of a quiz or a question
+func (v *quizValidator) validateScoring(path string, mode ScoringMode) {
+	switch mode {
+	case "", SCORING_MODE_ALL_OR_NOTHING, SCORING_MODE_PROPORTIONAL, SCORING_MODE_RIGHT_MINUS_WRONG:
+	default:
+		v.addf(path, "unknown scoring mode %q, expected %q, %q or %q", mode,
+			SCORING_MODE_ALL_OR_NOTHING, SCORING_MODE_PROPORTIONAL, SCORING_MODE_RIGHT_MINUS_WRONG)
+	}
+}
+
+// validateAnswers checks the answers of a question according to its type
+func (v *quizValidator) validateAnswers(path string, question *Question) {
+	switch question.QuestionType {
+	case QUESTION_TYPE_MCQ:
+		if len(question.Answers) == 0 {
+			v.addf(path+".answers", "a multiple choice question needs answers")
+			return
+		}
+	case QUESTION_TYPE_FREE_TEXT:
+		if len(question.Answers) > 0 {
+			v.addf(path+".answers", "a free text question must not have answers, got %d", len(question.Answers))
+		}
+		return
+	default:
+		v.addf(path+".questionType", "unknown question type %d, expected %d (MCQ) or %d (free text)",
+			question.QuestionType, QUESTION_TYPE_MCQ, QUESTION_TYPE_FREE_TEXT)
+		return
+	}
+
+	answerIndexes := make(map[int]int)
+	hasCorrectAnswer := false
+	for j, answer := range question.Answers {
+		answerPath := fmt.Sprintf("%s.answers[%d]", path, j)
+		if answer.ID <= 0 {
+			v.addf(answerPath+".id", "must be a positive integer, got %d", answer.ID)
+		} else if other, ok := answerIndexes[answer.ID]; ok {
+			v.addf(answerPath+".id", "duplicate answer ID %d, already used by %s.answers[%d]", answer.ID, path, other)
+		} else {
+			answerIndexes[answer.ID] = j
+		}
+		if strings.TrimSpace(answer.Title) == "" {
+			v.addf(answerPath+".title", "must not be empty")
+		}
+		switch answer.Correct {
+		case ANSWER_CORRECT_CORRECT:
+			hasCorrectAnswer = true
+		case ANSWER_CORRECT_INCORRECT:
+		default:
+			v.addf(answerPath+".correct", "must be %d (incorrect) or %d (correct), got %d",
+				ANSWER_CORRECT_INCORRECT, ANSWER_CORRECT_CORRECT, answer.Correct)
+		}
+	}
+	if !hasCorrectAnswer {
+		v.addf(path+".answers", "a multiple choice question needs at least one correct answer")
+	}
+}
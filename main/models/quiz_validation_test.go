@@ -0,0 +1,141 @@
+package models
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestQuizValidate(t *testing.T) {
+	mcq := func(id int, answers ...Answer) Question {
+		return Question{ID: id, Question: "Question?", QuestionType: QUESTION_TYPE_MCQ, Answers: answers}
+	}
+	answer := func(id int, correct AnswerCorrect) Answer {
+		return Answer{ID: id, Title: "Answer", Correct: correct}
+	}
+	freeText := Question{ID: 3, Question: "Why?", QuestionType: QUESTION_TYPE_FREE_TEXT}
+	negative := -1
+
+	tests := []struct {
+		name  string
+		quiz  Quiz
+		paths []string
+	}{
+		{
+			name: "Valid quiz",
+			quiz: Quiz{ID: 1, Title: "Quiz", Questions: []Question{
+				mcq(1, answer(1, ANSWER_CORRECT_CORRECT), answer(2, ANSWER_CORRECT_INCORRECT)),
+				mcq(2, answer(1, ANSWER_CORRECT_CORRECT)),
+				freeText,
+			}},
+		},
+		{
+			name:  "Missing quiz fields",
+			quiz:  Quiz{Type: 2},
+			paths: []string{"id", "title", "type", "questions"},
+		},
+		{
+			name: "Duplicate question IDs",
+			quiz: Quiz{ID: 1, Title: "Quiz", Questions: []Question{
+				mcq(1, answer(1, ANSWER_CORRECT_CORRECT)),
+				mcq(1, answer(1, ANSWER_CORRECT_CORRECT)),
+			}},
+			paths: []string{"questions[1].id"},
+		},
+		{
+			name: "Invalid answers",
+			quiz: Quiz{ID: 1, Title: "Quiz", Questions: []Question{
+				mcq(1, answer(1, ANSWER_CORRECT_INCORRECT), answer(2, ANSWER_CORRECT_INCORRECT), answer(1, 2),
+					Answer{ID: 4}),
+			}},
+			paths: []string{
+				"questions[0].answers[2].id", "questions[0].answers[2].correct",
+				"questions[0].answers[3].title", "questions[0].answers",
+			},
+		},
+		{
+			name: "Question without answers",
+			quiz: Quiz{ID: 1, Title: "Quiz", Questions: []Question{
+				{ID: 1, Question: " ", QuestionType: QUESTION_TYPE_MCQ},
+			}},
+			paths: []string{"questions[0].question", "questions[0].answers"},
+		},
+		{
+			name: "Free text question with answers",
+			quiz: Quiz{ID: 1, Title: "Quiz", Questions: []Question{
+				{ID: 1, Question: "Why?", QuestionType: QUESTION_TYPE_FREE_TEXT, Answers: []Answer{answer(1, ANSWER_CORRECT_CORRECT)}},
+			}},
+			paths: []string{"questions[0].answers"},
+		},
+		{
+			name: "Negative timing",
+			quiz: Quiz{ID: 1, Title: "Quiz", Timeout: &negative, ReadingTime: &negative, Questions: []Question{
+				{ID: 1, Question: "Why?", QuestionType: QUESTION_TYPE_FREE_TEXT, Timeout: &negative},
+			}},
+			paths: []string{"timeout", "readingTime", "questions[0].timeout"},
+		},
+		{
+			name: "Unknown scoring mode",
+			quiz: Quiz{ID: 1, Title: "Quiz", Scoring: "bestOf", Questions: []Question{
+				{ID: 1, Question: "Why?", QuestionType: QUESTION_TYPE_FREE_TEXT, Scoring: SCORING_MODE_PROPORTIONAL},
+				{ID: 2, Question: "How?", QuestionType: QUESTION_TYPE_FREE_TEXT, Scoring: "half"},
+			}},
+			paths: []string{"scoring", "questions[1].scoring"},
+		},
+		{
+			name: "Unknown question type",
+			quiz: Quiz{ID: 1, Title: "Quiz", Questions: []Question{
+				{ID: 1, Question: "Why?", QuestionType: 7},
+			}},
+			paths: []string{"questions[0].questionType"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.quiz.Validate()
+			if tt.paths == nil {
+				if err != nil {
+					t.Fatalf("Expected no error, got %v", err)
+				}
+				return
+			}
+			var validationErr *QuizValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("Expected a validation error, got %v", err)
+			}
+			paths := []string{}
+			for _, problem := range validationErr.Problems {
+				paths = append(paths, problem.Path)
+			}
+			if !slices.Equal(paths, tt.paths) {
+				t.Errorf("Expected problems at %v, got %v", tt.paths, err)
+			}
+		})
+	}
+}
+
+func TestParseQuizRefusesInvalidQuiz(t *testing.T) {
+	quiz, err := ParseQuiz(`{"id": 1, "title": "", "questions": [{"id": 1, "question": "Why?", "questionType": 3}]}`)
+	if quiz != nil {
+		t.Errorf("Expected quiz to be nil, got %+v", quiz)
+	}
+	expected := "invalid quiz, 2 problem(s): title: must not be empty; " +
+		"questions[0].questionType: unknown question type 3, expected 0 (MCQ) or 1 (free text)"
+	if err == nil || err.Error() != expected {
+		t.Errorf("Expected error %q, got %v", expected, err)
+	}
+}
+
+func TestShippedQuizzesAreValid(t *testing.T) {
+	repository, err := NewQuizRepository("../data")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	errs, _ := repository.Reload()
+	for path, err := range errs {
+		t.Errorf("Invalid quiz file %s: %v", path, err)
+	}
+	if len(repository.Quizzes()) == 0 {
+		t.Error("Expected quizzes to be shipped")
+	}
+}
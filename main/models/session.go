@@ -1,6 +1,162 @@
 package models
 
+import (
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SessionState represents the lifecycle state of a session
+type SessionState int
+
+const (
+	// SESSION_STATE_OPEN the session accepts learners, no quiz is running
+	SESSION_STATE_OPEN SessionState = iota
+	// SESSION_STATE_RUNNING a quiz is running in the session
+	SESSION_STATE_RUNNING
+	// SESSION_STATE_CLOSED the session has been garbage collected
+	SESSION_STATE_CLOSED
+)
+
+// SessionKey identifies a session within the instance of its users. Sessions
+// of different instances are isolated even if they share a session ID.
+type SessionKey struct {
+	InstanceName string
+	SessionID    string
+}
+
+func (key SessionKey) String() string {
+	return key.InstanceName + "/" + key.SessionID
+}
+
+// ErrSessionClosed is returned when an event is sent to a closed session
+var ErrSessionClosed = errors.New("session closed")
+
+// Session owns the state of a learning session.
+//
+// The quiz game of the session is only accessed from the session goroutine:
+// commands and timer events are sent to it as functions through Do and Post
+// and are executed one at a time.
 type Session struct {
-	SessionID string
-	QuizGame  *QuizGame
+	SessionID    string
+	InstanceName string
+	QuizGame     *QuizGame
+	CreatedAt    time.Time
+
+	// IdleSince is the time the last client left the session,
+	// zero while clients are connected. Owned by the hub.
+	IdleSince time.Time
+
+	state            atomic.Int32
+	connectedPlayers atomic.Int32
+
+	inbox     chan func()
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewSession creates an open session with its quiz game
+// and starts the session goroutine.
+func NewSession(key SessionKey) *Session {
+	session := &Session{
+		SessionID:    key.SessionID,
+		InstanceName: key.InstanceName,
+		CreatedAt:    time.Now(),
+		inbox:        make(chan func()),
+		done:         make(chan struct{}),
+	}
+	session.QuizGame = &QuizGame{
+		SessionID:    key.SessionID,
+		InstanceName: key.InstanceName,
+		GetConnectedPlayersCount: func() int {
+			return int(session.connectedPlayers.Load())
+		},
+		dispatch: session.Post,
+	}
+	session.SetState(SESSION_STATE_OPEN)
+	go session.run()
+	return session
+}
+
+// Key returns the key identifying the session within its instance
+func (session *Session) Key() SessionKey {
+	return SessionKey{InstanceName: session.InstanceName, SessionID: session.SessionID}
+}
+
+// run executes the session events until the session is closed
+func (session *Session) run() {
+	defer session.QuizGame.Stop()
+	for {
+		select {
+		case fn := <-session.inbox:
+			fn()
+		case <-session.done:
+			return
+		}
+	}
+}
+
+// Do executes fn on the session goroutine and waits for its completion
+func (session *Session) Do(fn func()) error {
+	completed := make(chan struct{})
+	select {
+	case session.inbox <- func() {
+		defer close(completed)
+		fn()
+	}:
+	case <-session.done:
+		return ErrSessionClosed
+	}
+	<-completed
+	return nil
+}
+
+// Post executes fn on the session goroutine without waiting for it.
+// fn is dropped if the session is closed.
+func (session *Session) Post(fn func()) {
+	go func() {
+		select {
+		case session.inbox <- fn:
+		case <-session.done:
+		}
+	}()
+}
+
+// State returns the lifecycle state of the session
+func (session *Session) State() SessionState {
+	return SessionState(session.state.Load())
+}
+
+// SetState updates the lifecycle state of the session
+func (session *Session) SetState(state SessionState) {
+	session.state.Store(int32(state))
+}
+
+// PlayerJoined increments the count of players connected to the session
+func (session *Session) PlayerJoined() {
+	session.connectedPlayers.Add(1)
+}
+
+// PlayerLeft decrements the count of players connected to the session
+func (session *Session) PlayerLeft() {
+	session.connectedPlayers.Add(-1)
+}
+
+// IsIdle returns true if no client has been connected to the session
+// for at least idleTimeout
+func (session *Session) IsIdle(now time.Time, idleTimeout time.Duration) bool {
+	return !session.IdleSince.IsZero() && now.Sub(session.IdleSince) >= idleTimeout
+}
+
+// Close marks the session closed and stops its goroutine,
+// which stops the running quiz of the session, if any.
+// Close does not wait for the session goroutine to exit.
+func (session *Session) Close() {
+	session.closeOnce.Do(func() {
+		log.Printf("closing session %s\n", session.Key())
+		session.SetState(SESSION_STATE_CLOSED)
+		close(session.done)
+	})
 }
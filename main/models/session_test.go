@@ -0,0 +1,158 @@
+package models
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingServices records the messages sent by a session
+type recordingServices struct {
+	mu       sync.Mutex
+	messages []interface{}
+	timeouts chan *QuizQuestionStatsMessage
+}
+
+func (r *recordingServices) commandServices() CommandServices {
+	return CommandServices{
+		MessageSender: func(user *User, message interface{}) error {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.messages = append(r.messages, message)
+			if stats, ok := message.(*QuizQuestionStatsMessage); ok && user.Login == "system" {
+				r.timeouts <- stats
+			}
+			return nil
+		},
+		GetQuiz: func(instanceName string, quizId int) (*Quiz, error) {
+			return ParseQuiz(validQuizJSON)
+		},
+	}
+}
+
+func newTestSession(t *testing.T, playersCount int, timeout time.Duration) (*Session, *recordingServices) {
+	session := NewSession(SessionKey{SessionID: "1"})
+	t.Cleanup(session.Close)
+	for i := 0; i < playersCount; i++ {
+		session.PlayerJoined()
+	}
+	services := &recordingServices{timeouts: make(chan *QuizQuestionStatsMessage, 10)}
+	err := session.Do(func() {
+		quiz, _ := services.commandServices().GetQuiz("", 1)
+		startQuiz(session, services.commandServices(), quiz, &User{Login: "facilitator"})
+		session.QuizGame.defaultQuestionTimeout = timeout
+		err := nextQuestion(&User{Login: "facilitator"}, session, services.commandServices())
+		if err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	return session, services
+}
+
+func TestSessionConcurrentLearners(t *testing.T) {
+	const learnersCount = 100
+	session, services := newTestSession(t, learnersCount, time.Hour)
+
+	var wg sync.WaitGroup
+	for i := 0; i < learnersCount; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			user := &User{Login: fmt.Sprintf("learner%d", i), SessionID: "1"}
+			msg := &QuizLearnerAnswerMessage{QuestionId: 101, Answers: []int{1001}}
+			var err error
+			doErr := session.Do(func() {
+				err = msg.Execute(user, session, services.commandServices())
+			})
+			if doErr != nil || err != nil {
+				t.Errorf("Expected no error, got %v %v", doErr, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	err := session.Do(func() {
+		if len(session.QuizGame.playerStats) != learnersCount {
+			t.Errorf("Expected %d player stats, got %d", learnersCount, len(session.QuizGame.playerStats))
+		}
+		for login, playerStat := range session.QuizGame.playerStats {
+			if playerStat.CountAnswered != 1 || playerStat.CountCorrect != 1 {
+				t.Errorf("Unexpected stats for %s: %+v", login, playerStat)
+			}
+		}
+		questionStats := session.QuizGame.questionStats[101]
+		if questionStats.AnswersStats[1001].Count != learnersCount {
+			t.Errorf("Expected %d answers, got %d", learnersCount, questionStats.AnswersStats[1001].Count)
+		}
+		if questionStats.QuestionStatus != QUESTION_STATUS_ENDED {
+			t.Errorf("Expected question to be ended, got %d", questionStats.QuestionStatus)
+		}
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+}
+
+func TestSessionTimeoutWhileLearnersAnswer(t *testing.T) {
+	const learnersCount = 50
+	session, services := newTestSession(t, learnersCount+1, 20*time.Millisecond)
+
+	var wg sync.WaitGroup
+	for i := 0; i < learnersCount; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			user := &User{Login: fmt.Sprintf("learner%d", i), SessionID: "1"}
+			msg := &QuizLearnerAnswerMessage{QuestionId: 101, Answers: []int{1002}}
+			time.Sleep(time.Duration(i%40) * time.Millisecond)
+			_ = session.Do(func() {
+				_ = msg.Execute(user, session, services.commandServices())
+			})
+		}(i)
+	}
+	wg.Wait()
+
+	select {
+	case stats := <-services.timeouts:
+		if stats.Status != QUESTION_STATUS_TIMEOUT {
+			t.Errorf("Expected timeout status, got %d", stats.Status)
+		}
+		if stats.AnswersStats[1001].Correct != ANSWER_CORRECT_CORRECT {
+			t.Errorf("Expected correct answers to be revealed, got %+v", stats.AnswersStats)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected timeout message")
+	}
+
+	err := session.Do(func() {
+		if _, ok := session.QuizGame.playerStats["system"]; ok {
+			t.Error("Expected timeout not to be counted as a player answer")
+		}
+		for login, playerStat := range session.QuizGame.playerStats {
+			if playerStat.CountAnswered != 1 {
+				t.Errorf("Unexpected stats for %s: %+v", login, playerStat)
+			}
+		}
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+}
+
+func TestSessionClosed(t *testing.T) {
+	session := NewSession(SessionKey{SessionID: "1"})
+	session.Close()
+	if session.State() != SESSION_STATE_CLOSED {
+		t.Errorf("Expected session to be closed, got %d", session.State())
+	}
+	err := session.Do(func() {
+		t.Error("Expected function not to be executed")
+	})
+	if err != ErrSessionClosed {
+		t.Errorf("Expected ErrSessionClosed, got %v", err)
+	}
+}
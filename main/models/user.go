@@ -1,8 +1,130 @@
 package models
 
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Maximum length of a nickname, in characters.
+const maxNicknameLength = 32
+
+// User is a connected user. Its identity is taken from the verified claims
+// of its token, only its nickname can be chosen by the client.
 type User struct {
 	UserID       string
 	Login        string
+	DisplayName  string
 	SessionID    string
 	InstanceName string
+	Roles        []Role
+	Permissions  map[Permission]bool
+
+	// Nickname is validated by the server, unique within the session.
+	// Only accessed from the session goroutine.
+	Nickname string
+}
+
+// Name returns the name displayed to the other users
+func (user *User) Name() string {
+	if user.Nickname != "" {
+		return user.Nickname
+	}
+	if user.DisplayName != "" {
+		return user.DisplayName
+	}
+	return user.Login
+}
+
+// SessionKey returns the key of the session of the user
+func (user *User) SessionKey() SessionKey {
+	return SessionKey{InstanceName: user.InstanceName, SessionID: user.SessionID}
+}
+
+// Recipient returns the recipient identifying the user
+func (user *User) Recipient() Recipient {
+	return Recipient{
+		Type: RECIPIENT_TYPE_LEARNER,
+		Id:   user.UserID,
+		Name: user.Name(),
+	}
+}
+
+// SetNickname validates the nickname and sets it if it is not used by
+// another user of the session
+func (user *User) SetNickname(nickname string, sessionUsers []*User) error {
+	nickname = strings.TrimSpace(nickname)
+	if nickname == "" || utf8.RuneCountInString(nickname) > maxNicknameLength {
+		return newCommandError(
+			ERROR_CODE_INVALID_NICKNAME, "nickname must contain between 1 and %d characters", maxNicknameLength,
+		)
+	}
+	if strings.IndexFunc(nickname, unicode.IsControl) >= 0 {
+		return newCommandError(ERROR_CODE_INVALID_NICKNAME, "nickname contains invalid characters")
+	}
+	for _, other := range sessionUsers {
+		if other.UserID == user.UserID {
+			continue
+		}
+		if strings.EqualFold(other.Login, nickname) || strings.EqualFold(other.Name(), nickname) {
+			return newCommandError(ERROR_CODE_NICKNAME_TAKEN, "nickname %s is already used in the session", nickname)
+		}
+	}
+	user.Nickname = nickname
+	return nil
+}
+
+// Role is a role of a user, granting a set of permissions
+type Role string
+
+const (
+	ROLE_LEARNER     Role = "learner"
+	ROLE_FACILITATOR Role = "facilitator"
+)
+
+// Permission is required by a command to be executed
+type Permission string
+
+const (
+	// PERMISSION_NONE the command can be executed by any user
+	PERMISSION_NONE         Permission = ""
+	PERMISSION_CHAT         Permission = "chat"
+	PERMISSION_QUIZ_ANSWER  Permission = "quiz:answer"
+	PERMISSION_QUIZ_CONTROL Permission = "quiz:control"
+	// PERMISSION_ADMIN grants access to the administration API for the
+	// instance of the user, it is not granted by any role
+	PERMISSION_ADMIN Permission = "admin"
+	// PERMISSION_ADMIN_GLOBAL grants access to the administration API for
+	// every instance, it is not granted by any role
+	PERMISSION_ADMIN_GLOBAL Permission = "admin:global"
+)
+
+// rolePermissions lists the permissions granted by each role
+var rolePermissions = map[Role][]Permission{
+	ROLE_LEARNER:     {PERMISSION_CHAT, PERMISSION_QUIZ_ANSWER},
+	ROLE_FACILITATOR: {PERMISSION_CHAT, PERMISSION_QUIZ_ANSWER, PERMISSION_QUIZ_CONTROL},
+}
+
+// NewPermissions computes the roles and permissions given by claim values.
+// A value is either a role, granting all the permissions of the role,
+// or directly a permission.
+func NewPermissions(values []string) ([]Role, map[Permission]bool) {
+	roles := []Role{}
+	permissions := make(map[Permission]bool)
+	for _, value := range values {
+		if granted, ok := rolePermissions[Role(value)]; ok {
+			roles = append(roles, Role(value))
+			for _, permission := range granted {
+				permissions[permission] = true
+			}
+			continue
+		}
+		permissions[Permission(value)] = true
+	}
+	return roles, permissions
+}
+
+// HasPermission returns true if the user has been granted the permission
+func (user *User) HasPermission(permission Permission) bool {
+	return permission == PERMISSION_NONE || user.Permissions[permission]
 }
@@ -0,0 +1,54 @@
+package models
+
+import (
+	"testing"
+)
+
+func TestNewPermissions(t *testing.T) {
+	roles, permissions := NewPermissions([]string{"facilitator", "admin:revoke"})
+	if len(roles) != 1 || roles[0] != ROLE_FACILITATOR {
+		t.Errorf("Expected facilitator role, got %v", roles)
+	}
+	for _, permission := range []Permission{
+		PERMISSION_CHAT, PERMISSION_QUIZ_ANSWER, PERMISSION_QUIZ_CONTROL, Permission("admin:revoke"),
+	} {
+		if !permissions[permission] {
+			t.Errorf("Expected permission %s to be granted", permission)
+		}
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	_, learnerPermissions := NewPermissions([]string{"learner"})
+	learner := &User{Login: "learner1", Permissions: learnerPermissions}
+	_, facilitatorPermissions := NewPermissions([]string{"facilitator"})
+	facilitator := &User{Login: "facilitator", Permissions: facilitatorPermissions}
+	nobody := &User{Login: "nobody"}
+
+	tests := []struct {
+		name    string
+		user    *User
+		command Command
+		allowed bool
+	}{
+		{"learner answers", learner, &QuizLearnerAnswerMessage{}, true},
+		{"learner chats", learner, &Message{}, true},
+		{"learner starts quiz", learner, &QuizStartMessage{}, false},
+		{"learner moves to next question", learner, &QuizNextQuestionMessage{}, false},
+		{"facilitator starts quiz", facilitator, &QuizStartMessage{}, true},
+		{"facilitator moves to next question", facilitator, &QuizNextQuestionMessage{}, true},
+		{"user without role connects", nobody, &UserConnectMessage{}, true},
+		{"user without role chats", nobody, &Message{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Authorize(tt.user, tt.command)
+			if tt.allowed && err != nil {
+				t.Errorf("Expected command to be allowed, got %v", err)
+			}
+			if !tt.allowed && ErrorCodeOf(err) != ERROR_CODE_FORBIDDEN {
+				t.Errorf("Expected FORBIDDEN error, got %v", err)
+			}
+		})
+	}
+}
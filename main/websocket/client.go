@@ -2,8 +2,11 @@ package websocket
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"sync"
 	"time"
 
 	"learnLoop/main/auth"
@@ -22,8 +25,20 @@ const (
 	// Send pings to peer with this period. Must be less than pongWait.
 	pingPeriod = (pongWait * 9) / 10
 
-	// Maximum message size allowed from peer.
-	maxMessageSize = 512
+	// Maximum message size allowed from peer, large enough for a refresh
+	// auth message carrying a token.
+	maxMessageSize = 8192
+
+	// Close code sent when the token of the client expires.
+	CloseTokenExpired = websocket.ClosePolicyViolation
+
+	// Close code sent when the token of the client is revoked or its user
+	// banned.
+	CloseRevoked = websocket.ClosePolicyViolation
+
+	// TokenSubprotocol is the Sec-WebSocket-Protocol value followed by the
+	// token, for browsers that cannot set the Authorization header.
+	TokenSubprotocol = "access_token"
 )
 
 var (
@@ -34,9 +49,13 @@ var (
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
+	// the origin depends on the instance of the user, it is checked by
+	// ServeWs once the user is authenticated
+	CheckOrigin: func(r *http.Request) bool { return true },
 }
 
 type (
+	ConnectHandler func(client *Client) error
 	CloseHandler   func(client *Client) error
 	MessageHandler func(client *Client, message []byte) error
 )
@@ -55,6 +74,60 @@ type Client struct {
 
 	CloseHandler   CloseHandler
 	MessageHandler MessageHandler
+
+	// Close requests handled by the writePump.
+	closeRequests chan closeRequest
+
+	// Sequence number of the last frame of the session when the client
+	// registered, the following frames are sent to it live and never
+	// replayed. Only accessed from the hub goroutine.
+	registeredSeq uint64
+
+	// Issuer of the token the connection was opened with, the tokens
+	// refreshing the connection must have the same issuer.
+	issuer string
+
+	// Timer closing the connection when the token expires.
+	expiryTimer *time.Timer
+	// jti of the token of the connection.
+	tokenID string
+	// Guards expiryTimer and tokenID.
+	authMux sync.Mutex
+}
+
+// closeRequest is a request to close the connection with a close frame
+type closeRequest struct {
+	code int
+	text string
+}
+
+// Close closes the connection with the given close code and reason,
+// after the messages already sent to the client.
+func (c *Client) Close(code int, text string) {
+	select {
+	case c.closeRequests <- closeRequest{code: code, text: text}:
+	default:
+		// the connection is already closing
+	}
+}
+
+// setToken records the token of the connection, which is closed when
+// expiresAt is reached. The connection does not expire if expiresAt is zero.
+func (c *Client) setToken(tokenID string, expiresAt time.Time) {
+	c.authMux.Lock()
+	defer c.authMux.Unlock()
+	c.tokenID = tokenID
+	if c.expiryTimer != nil {
+		c.expiryTimer.Stop()
+		c.expiryTimer = nil
+	}
+	if expiresAt.IsZero() {
+		return
+	}
+	c.expiryTimer = time.AfterFunc(time.Until(expiresAt), func() {
+		log.Printf("Token of user %s expired, closing connection", c.User.UserID)
+		c.Close(CloseTokenExpired, "token expired")
+	})
 }
 
 // readPump pumps messages from the websocket connection to the hub.
@@ -64,6 +137,7 @@ type Client struct {
 // reads from this goroutine.
 func (c *Client) readPump() {
 	defer func() {
+		c.setToken("", time.Time{})
 		c.Hub.unregister <- c
 		c.conn.Close()
 		err := c.CloseHandler(c)
@@ -76,8 +150,7 @@ func (c *Client) readPump() {
 	c.conn.SetPongHandler(func(string) error { c.conn.SetReadDeadline(time.Now().Add(pongWait)); return nil })
 	for {
 		_, message, err := c.conn.ReadMessage()
-
-		if _, ok := err.(*websocket.CloseError); ok {
+		if err != nil {
 			log.Printf("socket closed: %v", err)
 			break
 		}
@@ -117,6 +190,10 @@ func (c *Client) writePump() {
 				log.Printf("Error writing message: %v", err)
 				return
 			}
+		case request := <-c.closeRequests:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(request.code, request.text))
+			return
 		case <-ticker.C:
 			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
@@ -129,17 +206,9 @@ func (c *Client) writePump() {
 // serveWs handles websocket requests from the peer.
 func ServeWs(
 	hub *Hub, w http.ResponseWriter, r *http.Request,
+	clientConnectHandler ConnectHandler,
 	clientCloseHandler CloseHandler, clientMessageHandler MessageHandler,
 ) {
-	upgrader.CheckOrigin = func(r *http.Request) bool { return true }
-
-	// Extract JWT token from query parameters
-	token := r.URL.Query().Get("token")
-	if token == "" {
-		http.Error(w, "Missing authorization token", http.StatusUnauthorized)
-		return
-	}
-
 	// Extract session ID from query parameters
 	sessionID := r.URL.Query().Get("sessionId")
 	if sessionID == "" {
@@ -147,40 +216,203 @@ func ServeWs(
 		return
 	}
 
-	// Validate JWT token
-	userID, instanceName, err := auth.ValidateJWT(token)
+	// origins allowed for no instance are refused before the credentials of
+	// the request are used
+	if err := checkKnownOrigin(r); err != nil {
+		log.Printf("Rejected connection: %v", err)
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return
+	}
+	identity, subprotocol, err := authenticate(r)
 	if err != nil {
-		log.Printf("Invalid JWT token: %v", err)
+		log.Printf("Unauthorized connection: %v", err)
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
-	log.Printf("Authenticated user %s for session %s on instance %s", userID, sessionID, instanceName)
+	if err := checkOrigin(r, identity.InstanceName); err != nil {
+		log.Printf("Rejected connection of user %s: %v", identity.UserID, err)
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return
+	}
+	if err := auth.CheckSessionAccess(r.Context(), identity, sessionID); err != nil {
+		log.Printf("Refused connection of user %s: %v", identity.UserID, err)
+		if errors.Is(err, auth.ErrSessionAccessDenied) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		} else {
+			http.Error(w, "Session access policy unavailable", http.StatusServiceUnavailable)
+		}
+		return
+	}
+	log.Printf(
+		"Authenticated user %s for session %s on instance %s with roles %v",
+		identity.UserID, sessionID, identity.InstanceName, identity.Roles,
+	)
+	roles, permissions := models.NewPermissions(identity.Roles)
+	user := &models.User{
+		Login:        identity.Login,
+		DisplayName:  identity.DisplayName,
+		UserID:       identity.UserID,
+		SessionID:    sessionID,
+		InstanceName: identity.InstanceName,
+		Roles:        roles,
+		Permissions:  permissions,
+	}
+	if err := hub.Admit(user); err != nil {
+		log.Printf("Refused connection of user %s: %v", user.UserID, err)
+		http.Error(w, "Service unavailable: "+errors.Unwrap(err).Error(), http.StatusServiceUnavailable)
+		return
+	}
 
 	// Upgrade the HTTP connection to a WebSocket connection
-	conn, err := upgrader.Upgrade(w, r, nil)
+	var responseHeader http.Header
+	if subprotocol != "" {
+		responseHeader = http.Header{"Sec-Websocket-Protocol": {subprotocol}}
+	}
+	conn, err := upgrader.Upgrade(w, r, responseHeader)
 	if err != nil {
 		log.Println(err)
 		return
 	}
 	client := &Client{
-		Hub:  hub,
-		conn: conn,
-		send: make(chan []byte, 256),
-		User: &models.User{
-			Login:        "",
-			UserID:       userID,
-			SessionID:    sessionID,
-			InstanceName: instanceName,
-		},
+		Hub:            hub,
+		conn:           conn,
+		send:           make(chan []byte, 256),
+		User:           user,
+		issuer:         identity.Issuer,
 		CloseHandler:   clientCloseHandler,
 		MessageHandler: clientMessageHandler,
+		closeRequests:  make(chan closeRequest, 1),
 	}
 
-	// Register client with session ID
-	client.Hub.register <- client
+	// Register client with its session, other connections may have reached
+	// the limits of the instance since it was admitted
+	if err := client.Hub.register(client); err != nil {
+		log.Printf("Refused connection of user %s: %v", user.UserID, err)
+		conn.WriteControl(
+			websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseTryAgainLater, errors.Unwrap(err).Error()),
+			time.Now().Add(writeWait),
+		)
+		conn.Close()
+		return
+	}
+	client.setToken(identity.TokenID, identity.ExpiresAt)
 
 	// Allow collection of memory referenced by the caller by doing all work in
 	// new goroutines.
 	go client.writePump()
-	go client.readPump()
+	go func() {
+		if err := clientConnectHandler(client); err != nil {
+			log.Printf("Error handling client connection: %v\n", err)
+		}
+		client.readPump()
+	}()
+}
+
+// authenticate returns the identity of the client opening a connection and
+// the subprotocol to select. The client is identified, in order, by a connect
+// ticket, an Authorization: Bearer header, a token following TokenSubprotocol
+// in Sec-WebSocket-Protocol, or a token query parameter.
+func authenticate(r *http.Request) (*auth.Identity, string, error) {
+	if ticket := r.URL.Query().Get("ticket"); ticket != "" {
+		identity, err := connectTickets.redeem(ticket, time.Now())
+		return identity, "", err
+	}
+	if token := auth.BearerToken(r); token != "" {
+		identity, err := auth.ValidateJWT(token)
+		return identity, "", err
+	}
+	protocols := websocket.Subprotocols(r)
+	for i, protocol := range protocols {
+		if protocol == TokenSubprotocol && i+1 < len(protocols) {
+			identity, err := auth.ValidateJWT(protocols[i+1])
+			return identity, TokenSubprotocol, err
+		}
+	}
+	if token := r.URL.Query().Get("token"); token != "" {
+		identity, err := auth.ValidateJWT(token)
+		return identity, "", err
+	}
+	return nil, "", errors.New("missing authorization token")
+}
+
+// RefreshAuth validates a new token of the user and extends its connection
+// until the new token expires. The token must identify the same user and be
+// issued by the issuer of the token of the connection. The roles of the user
+// are replaced by the roles of the new token.
+func RefreshAuth(hub *Hub, user *models.User, token string) error {
+	identity, err := auth.ValidateJWT(token)
+	if err != nil {
+		return err
+	}
+	if identity.UserID != user.UserID || identity.InstanceName != user.InstanceName {
+		return fmt.Errorf("token of user %s cannot refresh the connection of user %s", identity.UserID, user.UserID)
+	}
+	var client *Client
+	hub.query(func() {
+		client = hub.userClient(user)
+	})
+	if client == nil {
+		return errors.New("connection not found")
+	}
+	if identity.Issuer != client.issuer {
+		return fmt.Errorf(
+			"token issued by %s cannot refresh a connection opened with a token issued by %s",
+			identity.Issuer, client.issuer,
+		)
+	}
+
+	roles, permissions := models.NewPermissions(identity.Roles)
+	setRoles := func() {
+		user.Roles, user.Permissions = roles, permissions
+	}
+	// the user is read by the session goroutine, the roles apply once it is
+	// done with the current command
+	if session := hub.GetSession(user.SessionKey()); session == nil || session.Do(setRoles) != nil {
+		setRoles()
+	}
+	client.setToken(identity.TokenID, identity.ExpiresAt)
+	log.Printf("Refreshed token of user %s until %v with roles %v", user.UserID, identity.ExpiresAt, identity.Roles)
+	return nil
+}
+
+// DisconnectRevoked closes the connections, in all sessions, of the token or
+// the user of the revocation
+func DisconnectRevoked(hub *Hub, revocation auth.Revocation) {
+	var clients []*Client
+	hub.query(func() {
+		for client := range hub.clients {
+			if client.revokedBy(revocation) {
+				clients = append(clients, client)
+			}
+		}
+	})
+	reason := "token revoked"
+	if revocation.Kind == auth.REVOCATION_KIND_USER {
+		reason = "user banned"
+	}
+	for _, client := range clients {
+		log.Printf(
+			"Closing connection of user %s in session %s: %s %s revoked",
+			client.User.UserID, client.User.SessionKey(), revocation.Kind, revocation.Value,
+		)
+		client.Close(CloseRevoked, reason)
+	}
+}
+
+// revokedBy tells whether the revocation applies to the connection
+func (c *Client) revokedBy(revocation auth.Revocation) bool {
+	switch revocation.Kind {
+	case auth.REVOCATION_KIND_USER:
+		return c.User.UserID == revocation.Value && c.User.InstanceName == revocation.InstanceName
+	case auth.REVOCATION_KIND_TOKEN:
+		if revocation.InstanceName != "" && c.User.InstanceName != revocation.InstanceName {
+			return false
+		}
+		c.authMux.Lock()
+		defer c.authMux.Unlock()
+		return c.tokenID != "" && c.tokenID == revocation.Value
+	default:
+		return false
+	}
 }
@@ -0,0 +1,423 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"learnLoop/main/auth"
+	"learnLoop/main/config"
+	"learnLoop/main/models"
+
+	"github.com/gorilla/websocket"
+)
+
+// testServer is a running hub served over HTTP, trusting the tokens of a
+// development identity provider served by the same server
+type testServer struct {
+	*httptest.Server
+	hub      *Hub
+	provider *auth.DevIdentityProvider
+	// errors returned by the message handler
+	errors chan error
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	previousDevMode := config.DevMode
+	config.DevMode = true
+	t.Cleanup(func() { config.DevMode = previousDevMode })
+
+	hub := NewHub(time.Minute, 10)
+	go hub.Run()
+	ts := &testServer{hub: hub, errors: make(chan error, 10)}
+	services := models.CommandServices{
+		RefreshAuth: func(user *models.User, token string) error {
+			return RefreshAuth(hub, user, token)
+		},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		ServeWs(hub, w, r,
+			func(client *Client) error { return nil },
+			func(client *Client) error { return nil },
+			func(client *Client, message []byte) error {
+				command, err := models.ParseCommand(message)
+				if err == nil {
+					err = (*command).(models.ConnectionCommand).ExecuteOnConnection(client.User, services)
+				}
+				ts.errors <- err
+				return err
+			},
+		)
+	})
+	ts.Server = httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+
+	issuer, err := auth.DevIssuer(ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	ts.provider, err = auth.NewDevIdentityProvider(issuer)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	mux.HandleFunc(auth.DevJWKSPath, ts.provider.ServeJWKS)
+	if err := auth.LoadTrustedIssuers([]config.IssuerConfig{{Issuer: issuer}}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	return ts
+}
+
+func (ts *testServer) token(t *testing.T, subject string, expiresIn time.Duration) string {
+	t.Helper()
+	token, err := ts.provider.MintToken(auth.DevTokenRequest{Subject: subject, ExpiresIn: expiresIn})
+	if err != nil {
+		t.Fatalf("Failed to mint token: %v", err)
+	}
+	return token
+}
+
+// dial opens a connection to the session 1 with the given query parameters
+// and request header
+func (ts *testServer) dial(query string, header http.Header) (*websocket.Conn, *http.Response, error) {
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws?sessionId=1&" + query
+	return websocket.DefaultDialer.Dial(url, header)
+}
+
+func (ts *testServer) connect(t *testing.T, token string) *websocket.Conn {
+	t.Helper()
+	conn, _, err := ts.dial("token="+token, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readCloseCode reads until the connection is closed and returns its close code
+func readCloseCode(t *testing.T, conn *websocket.Conn, timeout time.Duration) int {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	for {
+		_, _, err := conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		var closeErr *websocket.CloseError
+		if errors.As(err, &closeErr) {
+			return closeErr.Code
+		}
+		t.Fatalf("Expected connection to be closed, got %v", err)
+	}
+}
+
+func TestClientTokenExpiry(t *testing.T) {
+	ts := newTestServer(t)
+	conn := ts.connect(t, ts.token(t, "alice", time.Second))
+
+	if code := readCloseCode(t, conn, 3*time.Second); code != CloseTokenExpired {
+		t.Errorf("Expected close code %d, got %d", CloseTokenExpired, code)
+	}
+}
+
+func TestClientRefreshAuth(t *testing.T) {
+	ts := newTestServer(t)
+
+	t.Run("Token of the same user extends the connection", func(t *testing.T) {
+		conn := ts.connect(t, ts.token(t, "alice", time.Second))
+		err := conn.WriteJSON(models.RefreshAuthMessage{
+			Envelope: &models.Envelope{Type: models.MESSAGE_TYPE_REFRESH_AUTH},
+			Token:    ts.token(t, "alice", time.Hour),
+		})
+		if err != nil {
+			t.Fatalf("Failed to send refresh auth message: %v", err)
+		}
+		if err := <-ts.errors; err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, _, err = conn.ReadMessage()
+		var closeErr *websocket.CloseError
+		if errors.As(err, &closeErr) {
+			t.Errorf("Expected connection to stay open, got %v", err)
+		}
+	})
+
+	t.Run("Token of another user is rejected", func(t *testing.T) {
+		conn := ts.connect(t, ts.token(t, "alice", time.Second))
+		err := conn.WriteJSON(models.RefreshAuthMessage{
+			Envelope: &models.Envelope{Type: models.MESSAGE_TYPE_REFRESH_AUTH},
+			Token:    ts.token(t, "mallory", time.Hour),
+		})
+		if err != nil {
+			t.Fatalf("Failed to send refresh auth message: %v", err)
+		}
+		err = <-ts.errors
+		if models.ErrorCodeOf(err) != models.ERROR_CODE_INVALID_TOKEN {
+			t.Errorf("Expected INVALID_TOKEN error, got %v", err)
+		}
+		if code := readCloseCode(t, conn, 3*time.Second); code != CloseTokenExpired {
+			t.Errorf("Expected close code %d, got %d", CloseTokenExpired, code)
+		}
+	})
+
+	t.Run("Token with other roles replaces the permissions", func(t *testing.T) {
+		mint := func(role string) string {
+			token, err := ts.provider.MintToken(auth.DevTokenRequest{Subject: "carol", Role: role})
+			if err != nil {
+				t.Fatalf("Failed to mint token: %v", err)
+			}
+			return token
+		}
+		conn := ts.connect(t, mint("facilitator"))
+		err := conn.WriteJSON(models.RefreshAuthMessage{
+			Envelope: &models.Envelope{Type: models.MESSAGE_TYPE_REFRESH_AUTH},
+			Token:    mint("learner"),
+		})
+		if err != nil {
+			t.Fatalf("Failed to send refresh auth message: %v", err)
+		}
+		if err := <-ts.errors; err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		var user *models.User
+		ts.hub.query(func() {
+			for client := range ts.hub.clients {
+				if client.User.UserID == "carol" {
+					user = client.User
+				}
+			}
+		})
+		if user == nil || user.HasPermission(models.PERMISSION_QUIZ_CONTROL) || !user.HasPermission(models.PERMISSION_QUIZ_ANSWER) {
+			t.Errorf("Expected carol to have the permissions of a learner only, got %+v", user)
+		}
+	})
+
+	t.Run("Token of another issuer is rejected", func(t *testing.T) {
+		mux := http.NewServeMux()
+		otherServer := httptest.NewServer(mux)
+		defer otherServer.Close()
+		otherIssuer, err := auth.DevIssuer(otherServer.Listener.Addr().String())
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		otherProvider, err := auth.NewDevIdentityProvider(otherIssuer)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		mux.HandleFunc(auth.DevJWKSPath, otherProvider.ServeJWKS)
+		issuer, _ := auth.DevIssuer(ts.Listener.Addr().String())
+		err = auth.LoadTrustedIssuers([]config.IssuerConfig{{Issuer: issuer}, {Issuer: otherIssuer}})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		otherToken, err := otherProvider.MintToken(auth.DevTokenRequest{Subject: "alice"})
+		if err != nil {
+			t.Fatalf("Failed to mint token: %v", err)
+		}
+
+		conn := ts.connect(t, ts.token(t, "alice", time.Second))
+		err = conn.WriteJSON(models.RefreshAuthMessage{
+			Envelope: &models.Envelope{Type: models.MESSAGE_TYPE_REFRESH_AUTH},
+			Token:    otherToken,
+		})
+		if err != nil {
+			t.Fatalf("Failed to send refresh auth message: %v", err)
+		}
+		err = <-ts.errors
+		if models.ErrorCodeOf(err) != models.ERROR_CODE_INVALID_TOKEN {
+			t.Errorf("Expected INVALID_TOKEN error, got %v", err)
+		}
+		if code := readCloseCode(t, conn, 3*time.Second); code != CloseTokenExpired {
+			t.Errorf("Expected close code %d, got %d", CloseTokenExpired, code)
+		}
+	})
+}
+
+func TestServeWsTokenTransports(t *testing.T) {
+	ts := newTestServer(t)
+	token := ts.token(t, "alice", time.Hour)
+
+	tests := []struct {
+		name        string
+		query       string
+		header      http.Header
+		subprotocol string
+		status      int
+	}{
+		{"Query parameter", "token=" + token, nil, "", http.StatusSwitchingProtocols},
+		{"Authorization header", "", http.Header{"Authorization": {"Bearer " + token}}, "", http.StatusSwitchingProtocols},
+		{
+			"Sec-WebSocket-Protocol", "",
+			http.Header{"Sec-Websocket-Protocol": {TokenSubprotocol + ", " + token}},
+			TokenSubprotocol, http.StatusSwitchingProtocols,
+		},
+		{"Missing token", "", nil, "", http.StatusUnauthorized},
+		{"Invalid token", "", http.Header{"Authorization": {"Bearer invalid"}}, "", http.StatusUnauthorized},
+		{"Other scheme", "", http.Header{"Authorization": {"Basic " + token}}, "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, resp, err := ts.dial(tt.query, tt.header)
+			if conn != nil {
+				defer conn.Close()
+			}
+			if resp == nil {
+				t.Fatalf("Expected a response, got %v", err)
+			}
+			if resp.StatusCode != tt.status {
+				t.Fatalf("Expected status %d, got %d (%v)", tt.status, resp.StatusCode, err)
+			}
+			if conn != nil && conn.Subprotocol() != tt.subprotocol {
+				t.Errorf("Expected subprotocol %q, got %q", tt.subprotocol, conn.Subprotocol())
+			}
+		})
+	}
+}
+
+func TestConnectTicket(t *testing.T) {
+	ts := newTestServer(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws/ticket", ServeConnectTicket)
+	ticketServer := httptest.NewServer(mux)
+	defer ticketServer.Close()
+
+	requestTicket := func(header string) (*http.Response, string) {
+		request, _ := http.NewRequest(http.MethodPost, ticketServer.URL+"/ws/ticket", nil)
+		if header != "" {
+			request.Header.Set("Authorization", header)
+		}
+		resp, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		defer resp.Body.Close()
+		var body struct {
+			Ticket string `json:"ticket"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&body)
+		return resp, body.Ticket
+	}
+
+	if resp, _ := requestTicket(""); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 without token, got %d", resp.StatusCode)
+	}
+	if resp, _ := requestTicket("Bearer invalid"); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 with an invalid token, got %d", resp.StatusCode)
+	}
+
+	resp, ticket := requestTicket("Bearer " + ts.token(t, "alice", time.Hour))
+	if resp.StatusCode != http.StatusOK || ticket == "" {
+		t.Fatalf("Expected a ticket, got status %d", resp.StatusCode)
+	}
+	conn, _, err := ts.dial("ticket="+ticket, nil)
+	if err != nil {
+		t.Fatalf("Expected ticket to open a connection, got %v", err)
+	}
+	conn.Close()
+
+	if _, resp, _ := ts.dial("ticket="+ticket, nil); resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Error("Expected a ticket to be usable only once")
+	}
+}
+
+func TestTicketStoreExpiry(t *testing.T) {
+	store := &ticketStore{tickets: make(map[string]connectTicket)}
+	now := time.Now()
+	identity := &auth.Identity{UserID: "alice", ExpiresAt: now.Add(10 * time.Second)}
+
+	ticket, expiresAt, err := store.issue(identity, time.Minute, now)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !expiresAt.Equal(identity.ExpiresAt) {
+		t.Errorf("Expected ticket not to outlive the token, got %v", expiresAt)
+	}
+	if _, err := store.redeem(ticket, now.Add(11*time.Second)); err == nil {
+		t.Error("Expected expired ticket to be rejected")
+	}
+
+	store.issue(identity, time.Second, now)
+	store.issue(identity, time.Second, now.Add(2*time.Second))
+	if len(store.tickets) != 1 {
+		t.Errorf("Expected expired tickets to be forgotten, got %d tickets", len(store.tickets))
+	}
+}
+
+func TestTicketStoreRevoked(t *testing.T) {
+	store, _ := auth.NewRevocationStore("")
+	auth.SetRevocationStore(store)
+	t.Cleanup(func() {
+		empty, _ := auth.NewRevocationStore("")
+		auth.SetRevocationStore(empty)
+	})
+	tickets := &ticketStore{tickets: make(map[string]connectTicket)}
+	identity := &auth.Identity{UserID: "alice", TokenID: "token1", InstanceName: "dev"}
+
+	tests := []struct {
+		name       string
+		revocation auth.Revocation
+	}{
+		{"Token revoked after the ticket is issued", auth.Revocation{Kind: auth.REVOCATION_KIND_TOKEN, Value: "token1"}},
+		{"User banned after the ticket is issued", auth.Revocation{Kind: auth.REVOCATION_KIND_USER, Value: "alice", InstanceName: "dev"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Now()
+			ticket, _, err := tickets.issue(identity, time.Minute, now)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if err := store.Revoke(tt.revocation); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			defer store.Unrevoke(tt.revocation.Kind, tt.revocation.InstanceName, tt.revocation.Value)
+
+			_, err = tickets.redeem(ticket, now)
+			if reason := auth.RejectionReasonOf(err); err == nil || reason != auth.REJECTION_REASON_REVOKED {
+				t.Errorf("Expected rejection %s, got %v", auth.REJECTION_REASON_REVOKED, err)
+			}
+		})
+	}
+}
+
+func TestServeWsSessionAccessPolicy(t *testing.T) {
+	ts := newTestServer(t)
+	auth.SetSessionAccessPolicy(&auth.ClaimSessionPolicy{Claim: "sessions"})
+	t.Cleanup(func() {
+		policy, _ := auth.NewSessionAccessPolicy(config.SessionPolicy{})
+		auth.SetSessionAccessPolicy(policy)
+	})
+
+	_, resp, err := ts.dial("token="+ts.token(t, "alice", time.Hour), nil)
+	if resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected status 403, got %v", err)
+	}
+}
+
+func TestDisconnectRevoked(t *testing.T) {
+	ts := newTestServer(t)
+	alice := ts.connect(t, ts.token(t, "alice", time.Hour))
+	bob := ts.connect(t, ts.token(t, "bob", time.Hour))
+
+	// bob of another instance is banned, not bob of the dev instance
+	DisconnectRevoked(ts.hub, auth.Revocation{Kind: auth.REVOCATION_KIND_USER, Value: "bob", InstanceName: "other"})
+	DisconnectRevoked(ts.hub, auth.Revocation{Kind: auth.REVOCATION_KIND_USER, Value: "alice", InstanceName: "dev"})
+	if code := readCloseCode(t, alice, 3*time.Second); code != CloseRevoked {
+		t.Errorf("Expected close code %d, got %d", CloseRevoked, code)
+	}
+
+	bob.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	_, _, err := bob.ReadMessage()
+	var closeErr *websocket.CloseError
+	if errors.As(err, &closeErr) {
+		t.Errorf("Expected connections of other users to stay open, got %v", err)
+	}
+}
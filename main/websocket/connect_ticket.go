@@ -0,0 +1,112 @@
+package websocket
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"learnLoop/main/auth"
+	"learnLoop/main/config"
+)
+
+// connectTicket is a one-time ticket standing for an authenticated identity
+type connectTicket struct {
+	identity  *auth.Identity
+	expiresAt time.Time
+}
+
+// ticketStore keeps the connect tickets until they are redeemed or expire
+type ticketStore struct {
+	mu      sync.Mutex
+	tickets map[string]connectTicket
+}
+
+var connectTickets = &ticketStore{tickets: make(map[string]connectTicket)}
+
+// issue returns a new opaque ticket for the identity, valid for ttl but
+// never after the expiry of the token of the identity
+func (s *ticketStore) issue(identity *auth.Identity, ttl time.Duration, now time.Time) (string, time.Time, error) {
+	value := make([]byte, 32)
+	if _, err := rand.Read(value); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to generate ticket: %w", err)
+	}
+	ticket := base64.RawURLEncoding.EncodeToString(value)
+	expiresAt := now.Add(ttl)
+	if !identity.ExpiresAt.IsZero() && identity.ExpiresAt.Before(expiresAt) {
+		expiresAt = identity.ExpiresAt
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// forget expired tickets that were never redeemed
+	for key, existing := range s.tickets {
+		if !now.Before(existing.expiresAt) {
+			delete(s.tickets, key)
+		}
+	}
+	s.tickets[ticket] = connectTicket{identity: identity, expiresAt: expiresAt}
+	return ticket, expiresAt, nil
+}
+
+// redeem returns the identity of a ticket, which cannot be used again. The
+// token or the user of the identity may have been revoked since the ticket
+// was issued.
+func (s *ticketStore) redeem(ticket string, now time.Time) (*auth.Identity, error) {
+	s.mu.Lock()
+	existing, ok := s.tickets[ticket]
+	delete(s.tickets, ticket)
+	s.mu.Unlock()
+	if !ok {
+		return nil, errors.New("unknown connect ticket")
+	}
+	if !now.Before(existing.expiresAt) {
+		return nil, errors.New("connect ticket expired")
+	}
+	if err := auth.CheckRevocation(existing.identity); err != nil {
+		return nil, err
+	}
+	return existing.identity, nil
+}
+
+// ServeConnectTicket issues a one-time connect ticket to the client
+// authenticated by its Authorization: Bearer header. The ticket is used in
+// the ticket query parameter of the websocket URL instead of the token.
+func ServeConnectTicket(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token := auth.BearerToken(r)
+	if token == "" {
+		http.Error(w, "Missing authorization token", http.StatusUnauthorized)
+		return
+	}
+	identity, err := auth.ValidateJWT(token)
+	if err != nil {
+		log.Printf("Invalid JWT token: %v", err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	ticket, expiresAt, err := connectTickets.issue(identity, config.ConnectTicketTTL, time.Now())
+	if err != nil {
+		log.Printf("Error issuing connect ticket: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	err = json.NewEncoder(w).Encode(struct {
+		Ticket    string    `json:"ticket"`
+		ExpiresAt time.Time `json:"expiresAt"`
+	}{ticket, expiresAt})
+	if err != nil {
+		log.Printf("Error writing connect ticket: %v", err)
+	}
+}
@@ -2,120 +2,409 @@ package websocket
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"time"
 
+	"learnLoop/main/config"
 	"learnLoop/main/models"
 )
 
-// Hub maintains the set of active clients and broadcasts messages to the
-// clients.
+// Period at which idle sessions are garbage collected.
+const sessionGCPeriod = time.Minute
+
+// Errors of the connections refused by the limits of their instance.
+var (
+	ErrTooManySessions    = errors.New("too many sessions")
+	ErrTooManyConnections = errors.New("too many connections")
+)
+
+// routedMessage is a marshaled message together with the session it belongs
+// to and the recipients it must be delivered to.
+// When user is set, the message is delivered to the connection of that user only.
+type routedMessage struct {
+	session models.SessionKey
+	to      []models.Recipient
+	user    *models.User
+	payload []byte
+}
+
+// Hub maintains the set of active clients and routes messages to the
+// clients of a session.
 type Hub struct {
 	// Registered clients.
 	clients map[*Client]bool
 
-	// Clients organized by session ID
-	sessionClients map[string][]*Client
+	// Clients organized by session, sessions of different instances are
+	// never mixed
+	sessionClients map[models.SessionKey][]*Client
 
-	sessions map[string]*models.Session
+	sessions map[models.SessionKey]*models.Session
 
-	// Inbound messages from the clients.
-	broadcast chan []byte
+	// Number of sessions and clients of each instance.
+	instanceSessions    map[string]int
+	instanceConnections map[string]int
 
-	// Register requests from the clients.
-	register chan *Client
+	// Outbound messages routed to the recipients of a session.
+	broadcast chan routedMessage
 
 	// Unregister requests from clients.
 	unregister chan *Client
+
+	// Functions reading the hub state, executed on the hub goroutine.
+	queries chan func()
+
+	// Duration after which a session without any client is closed.
+	sessionIdleTimeout time.Duration
+
+	// Sequence numbers and last frames sent of each session.
+	replayBuffers    map[models.SessionKey]*replayBuffer
+	replayBufferSize int
 }
 
-func NewHub() *Hub {
+func NewHub(sessionIdleTimeout time.Duration, replayBufferSize int) *Hub {
 	return &Hub{
-		broadcast:      make(chan []byte),
-		register:       make(chan *Client),
-		unregister:     make(chan *Client),
-		clients:        make(map[*Client]bool),
-		sessions:       make(map[string]*models.Session),
-		sessionClients: make(map[string][]*Client),
+		broadcast:           make(chan routedMessage),
+		unregister:          make(chan *Client),
+		queries:             make(chan func()),
+		clients:             make(map[*Client]bool),
+		sessions:            make(map[models.SessionKey]*models.Session),
+		sessionClients:      make(map[models.SessionKey][]*Client),
+		instanceSessions:    make(map[string]int),
+		instanceConnections: make(map[string]int),
+		sessionIdleTimeout:  sessionIdleTimeout,
+		replayBuffers:       make(map[models.SessionKey]*replayBuffer),
+		replayBufferSize:    replayBufferSize,
 	}
 }
 
-func (hub *Hub) GetUsersInSession(sessionID string) []*models.User {
-	clients := hub.sessionClients[sessionID]
-	users := make([]*models.User, len(clients))
-	for i, c := range clients {
-		users[i] = c.User
+// query executes fn on the hub goroutine and waits for its completion.
+// fn must not block as the hub does not route messages meanwhile.
+func (hub *Hub) query(fn func()) {
+	completed := make(chan struct{})
+	hub.queries <- func() {
+		defer close(completed)
+		fn()
 	}
+	<-completed
+}
+
+func (hub *Hub) GetUsersInSession(key models.SessionKey) []*models.User {
+	var users []*models.User
+	hub.query(func() {
+		clients := hub.sessionClients[key]
+		users = make([]*models.User, len(clients))
+		for i, c := range clients {
+			users[i] = c.User
+		}
+	})
 	return users
 }
 
-func SendMessageToAllClients(hub *Hub, msg any) error {
+// SendMessage routes msg to the clients of the given session.
+// If msg carries its own recipients list, it is delivered to those
+// recipients only, otherwise it is delivered to the whole session.
+func SendMessage(hub *Hub, key models.SessionKey, msg any) error {
+	to := []models.Recipient{{Type: models.RECIPIENT_TYPE_SESSION, Id: key.SessionID}}
+	if addressable, ok := msg.(models.Addressable); ok && len(addressable.Recipients()) > 0 {
+		to = addressable.Recipients()
+	}
+	return SendMessageToRecipients(hub, key, to, msg)
+}
+
+// SendMessageToRecipients routes msg to the given recipients of a session.
+// Recipients outside of the session are ignored.
+func SendMessageToRecipients(hub *Hub, key models.SessionKey, to []models.Recipient, msg any) error {
+	jsonMessage, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Error marshaling JsonMessage: %v\n", err)
+		return err
+	}
+	hub.broadcast <- routedMessage{
+		session: key,
+		to:      to,
+		payload: jsonMessage,
+	}
+	return nil
+}
+
+// SendMessageToUser sends msg to the connection owning the given user only.
+func SendMessageToUser(hub *Hub, user *models.User, msg any) error {
 	jsonMessage, err := json.Marshal(msg)
 	if err != nil {
 		log.Printf("Error marshaling JsonMessage: %v\n", err)
 		return err
 	}
-	hub.broadcast <- jsonMessage
+	hub.broadcast <- routedMessage{
+		session: user.SessionKey(),
+		user:    user,
+		payload: jsonMessage,
+	}
+	return nil
+}
+
+func (h *Hub) GetSession(key models.SessionKey) *models.Session {
+	var session *models.Session
+	h.query(func() {
+		session = h.sessions[key]
+	})
+	return session
+}
+
+// LastSeq returns the sequence number of the last frame sent to the session
+func (h *Hub) LastSeq(key models.SessionKey) uint64 {
+	var lastSeq uint64
+	h.query(func() {
+		if buffer, ok := h.replayBuffers[key]; ok {
+			lastSeq = buffer.lastSeq
+		}
+	})
+	return lastSeq
+}
+
+// Replay sends to the connection of the given user the frames of its
+// session following lastSeq that were addressed to it, up to the frames it
+// received live since it registered, so that frames are neither duplicated
+// nor reordered.
+// It returns false if some of these frames are not available anymore,
+// in which case nothing is sent.
+func (h *Hub) Replay(user *models.User, lastSeq uint64) bool {
+	replayed := false
+	h.query(func() {
+		client := h.userClient(user)
+		if client == nil {
+			return
+		}
+		buffer, ok := h.replayBuffers[user.SessionKey()]
+		if !ok {
+			replayed = lastSeq == 0
+			return
+		}
+		frames, ok := buffer.since(lastSeq)
+		if !ok {
+			return
+		}
+		replayed = true
+		log.Printf("replaying frames after %d up to %d to user %s", lastSeq, client.registeredSeq, user.UserID)
+		for _, frame := range frames {
+			if frame.seq > client.registeredSeq {
+				break
+			}
+			if !clientMatches(client, user.SessionID, frame.to) {
+				continue
+			}
+			select {
+			case client.send <- frame.payload:
+			default:
+				removeClient(h, client)
+				return
+			}
+		}
+	})
+	return replayed
+}
+
+// userClient returns the client owning the given user, if registered
+func (h *Hub) userClient(user *models.User) *Client {
+	for _, c := range h.sessionClients[user.SessionKey()] {
+		if c.User == user {
+			return c
+		}
+	}
+	return nil
+}
+
+// clientMatches returns true if the client of the session is one of
+// the recipients
+func clientMatches(client *Client, sessionID string, to []models.Recipient) bool {
+	for _, recipient := range to {
+		switch recipient.Type {
+		case models.RECIPIENT_TYPE_SESSION:
+			if recipient.Id == sessionID {
+				return true
+			}
+		case models.RECIPIENT_TYPE_LEARNER:
+			if client.User.UserID == recipient.Id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// recipientClients returns the clients of the message session matching
+// at least one of its recipients, each client being returned once.
+// Recipients outside of the message session are ignored.
+func (h *Hub) recipientClients(message routedMessage) []*Client {
+	if message.user != nil {
+		if client := h.userClient(message.user); client != nil {
+			return []*Client{client}
+		}
+		return []*Client{}
+	}
+	clients := []*Client{}
+	for _, c := range h.sessionClients[message.session] {
+		if clientMatches(c, message.session.SessionID, message.to) {
+			clients = append(clients, c)
+		}
+	}
+	return clients
+}
+
+// sequence stamps a session frame with the next sequence number of the
+// session and keeps it for replay. Frames sent to a single connection are
+// not sequenced.
+func (h *Hub) sequence(message routedMessage) []byte {
+	if message.user != nil {
+		return message.payload
+	}
+	buffer, ok := h.replayBuffers[message.session]
+	if !ok {
+		buffer = newReplayBuffer(h.replayBufferSize)
+		h.replayBuffers[message.session] = buffer
+	}
+	return buffer.append(message.to, message.payload).payload
+}
+
+// admit checks that the limits of the instance of the user allow it to
+// join its session
+func (h *Hub) admit(user *models.User) error {
+	instance := config.Instances[user.InstanceName]
+	if instance.MaxConnections > 0 && h.instanceConnections[user.InstanceName] >= instance.MaxConnections {
+		return fmt.Errorf("instance %s: %w", user.InstanceName, ErrTooManyConnections)
+	}
+	_, open := h.sessions[user.SessionKey()]
+	if !open && instance.MaxSessions > 0 && h.instanceSessions[user.InstanceName] >= instance.MaxSessions {
+		return fmt.Errorf("instance %s: %w", user.InstanceName, ErrTooManySessions)
+	}
 	return nil
 }
 
-func (h *Hub) GetSession(sessionId string) *models.Session {
-	return h.sessions[sessionId]
+// Admit checks that the limits of the instance of the user allow it to join
+// its session, before its connection is upgraded
+func (h *Hub) Admit(user *models.User) error {
+	var err error
+	h.query(func() {
+		err = h.admit(user)
+	})
+	return err
+}
+
+// register registers the client if the limits of its instance allow it
+func (h *Hub) register(client *Client) error {
+	var err error
+	h.query(func() {
+		if err = h.admit(client.User); err == nil {
+			registerClient(h, client)
+		}
+	})
+	return err
+}
+
+func registerClient(h *Hub, client *Client) {
+	key := client.User.SessionKey()
+	log.Printf("registering client for user %s in session %s", client.User.UserID, key)
+	h.clients[client] = true
+	h.instanceConnections[key.InstanceName]++
+	if buffer, ok := h.replayBuffers[key]; ok {
+		client.registeredSeq = buffer.lastSeq
+	}
+
+	// Associate client with its session
+	h.sessionClients[key] = append(h.sessionClients[key], client)
+	h.getOrCreateSession(key).PlayerJoined()
 }
 
 func removeClient(h *Hub, client *Client) {
-	log.Printf("unregistering client for user %s in session %s", client.User.UserID, client.User.SessionID)
+	key := client.User.SessionKey()
+	log.Printf("unregistering client for user %s in session %s", client.User.UserID, key)
 	if _, ok := h.clients[client]; ok {
 		delete(h.clients, client)
 		close(client.send)
+		if h.instanceConnections[key.InstanceName]--; h.instanceConnections[key.InstanceName] == 0 {
+			delete(h.instanceConnections, key.InstanceName)
+		}
 
 		// Remove client from session
-		if clients, ok := h.sessionClients[client.User.SessionID]; ok {
+		if clients, ok := h.sessionClients[key]; ok {
 			for i, c := range clients {
 				if c == client {
-					h.sessionClients[client.User.SessionID] = append(clients[:i], clients[i+1:]...)
+					h.sessionClients[key] = append(clients[:i], clients[i+1:]...)
 					break
 				}
 			}
 		}
+		session, hasSession := h.sessions[key]
+		if hasSession {
+			session.PlayerLeft()
+		}
+		if len(h.sessionClients[key]) == 0 {
+			delete(h.sessionClients, key)
+			if hasSession {
+				session.IdleSince = time.Now()
+			}
+		}
+	}
+}
+
+// getOrCreateSession returns the session with the given key,
+// creating it on first join.
+func (h *Hub) getOrCreateSession(key models.SessionKey) *models.Session {
+	session, ok := h.sessions[key]
+	if ok {
+		session.IdleSince = time.Time{}
+		return session
+	}
+	log.Printf("creating session %s", key)
+	session = models.NewSession(key)
+	h.sessions[key] = session
+	h.instanceSessions[key.InstanceName]++
+	return session
+}
+
+// collectIdleSessions closes and forgets the sessions without any client
+// since at least sessionIdleTimeout.
+func (h *Hub) collectIdleSessions(now time.Time) {
+	for key, session := range h.sessions {
+		if session.IsIdle(now, h.sessionIdleTimeout) {
+			log.Printf("session %s idle since %v, closing it", key, session.IdleSince)
+			session.Close()
+			delete(h.sessions, key)
+			delete(h.replayBuffers, key)
+			if h.instanceSessions[key.InstanceName]--; h.instanceSessions[key.InstanceName] == 0 {
+				delete(h.instanceSessions, key.InstanceName)
+			}
+		}
 	}
 }
 
 func (h *Hub) Run() {
 	log.Println("hub is running")
+	gcTicker := time.NewTicker(sessionGCPeriod)
+	defer gcTicker.Stop()
 	for {
 		select {
-		case client := <-h.register:
-			log.Printf("registering client for user %s in session %s", client.User.UserID, client.User.SessionID)
-			h.clients[client] = true
-
-			// Associate client with session ID
-			h.sessionClients[client.User.SessionID] = append(
-				h.sessionClients[client.User.SessionID],
-				client,
-			)
-
-			h.sessions[client.User.SessionID] = &models.Session{
-				SessionID: client.User.SessionID,
-				QuizGame: &models.QuizGame{
-					SessionID: client.User.SessionID,
-					GetConnectedPlayersCount: func() int {
-						return len(h.sessionClients[client.User.SessionID])
-					},
-				},
-			}
-
 		case client := <-h.unregister:
 			removeClient(h, client)
 		case message := <-h.broadcast:
-			log.Printf("sending message '%s' to all clients(%d)", message, len(h.clients))
-			for client := range h.clients {
+			payload := h.sequence(message)
+			clients := h.recipientClients(message)
+			log.Printf(
+				"sending message '%s' to %d client(s) of session %s",
+				payload, len(clients), message.session,
+			)
+			for _, client := range clients {
 				select {
-				case client.send <- message:
+				case client.send <- payload:
 				default:
-					close(client.send)
 					removeClient(h, client)
 				}
 			}
+		case query := <-h.queries:
+			query()
+		case now := <-gcTicker.C:
+			h.collectIdleSessions(now)
 		}
 	}
 }
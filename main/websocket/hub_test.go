@@ -0,0 +1,225 @@
+package websocket
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"learnLoop/main/config"
+	"learnLoop/main/models"
+)
+
+func newTestClient(hub *Hub, login string, key models.SessionKey) *Client {
+	client := &Client{
+		Hub:  hub,
+		send: make(chan []byte, 1),
+		User: &models.User{
+			Login: login, UserID: "id-" + login, SessionID: key.SessionID, InstanceName: key.InstanceName,
+		},
+	}
+	hub.clients[client] = true
+	hub.sessionClients[key] = append(hub.sessionClients[key], client)
+	return client
+}
+
+func sessionKey(instanceName string, sessionID string) models.SessionKey {
+	return models.SessionKey{InstanceName: instanceName, SessionID: sessionID}
+}
+
+func TestHubRecipientClients(t *testing.T) {
+	hub := NewHub(time.Minute, 10)
+	alice := newTestClient(hub, "alice", sessionKey("instance1", "1"))
+	bob := newTestClient(hub, "bob", sessionKey("instance1", "1"))
+	newTestClient(hub, "carol", sessionKey("instance1", "2"))
+	newTestClient(hub, "dave", sessionKey("instance2", "1"))
+	// mallory picked the user ID of alice as login
+	mallory := newTestClient(hub, "id-alice", sessionKey("instance1", "1"))
+
+	tests := []struct {
+		name     string
+		to       []models.Recipient
+		expected []*Client
+	}{
+		{
+			name:     "session recipient",
+			to:       []models.Recipient{{Type: models.RECIPIENT_TYPE_SESSION, Id: "1"}},
+			expected: []*Client{alice, bob, mallory},
+		},
+		{
+			name:     "other session recipient is ignored",
+			to:       []models.Recipient{{Type: models.RECIPIENT_TYPE_SESSION, Id: "2"}},
+			expected: []*Client{},
+		},
+		{
+			name:     "learner recipient",
+			to:       []models.Recipient{{Type: models.RECIPIENT_TYPE_LEARNER, Id: "id-bob"}},
+			expected: []*Client{bob},
+		},
+		{
+			name:     "learner recipient by login is ignored",
+			to:       []models.Recipient{{Type: models.RECIPIENT_TYPE_LEARNER, Id: "bob"}},
+			expected: []*Client{},
+		},
+		{
+			name:     "login equal to the user ID of another learner",
+			to:       []models.Recipient{{Type: models.RECIPIENT_TYPE_LEARNER, Id: "id-alice"}},
+			expected: []*Client{alice},
+		},
+		{
+			name:     "learner of another session",
+			to:       []models.Recipient{{Type: models.RECIPIENT_TYPE_LEARNER, Id: "id-carol"}},
+			expected: []*Client{},
+		},
+		{
+			name:     "learner of the same session ID in another instance",
+			to:       []models.Recipient{{Type: models.RECIPIENT_TYPE_LEARNER, Id: "id-dave"}},
+			expected: []*Client{},
+		},
+		{
+			name: "client is selected once",
+			to: []models.Recipient{
+				{Type: models.RECIPIENT_TYPE_LEARNER, Id: "id-alice"},
+				{Type: models.RECIPIENT_TYPE_SESSION, Id: "1"},
+			},
+			expected: []*Client{alice, bob, mallory},
+		},
+	}
+
+	t.Run("user connection only", func(t *testing.T) {
+		clients := hub.recipientClients(routedMessage{session: sessionKey("instance1", "1"), user: bob.User})
+		if len(clients) != 1 || clients[0] != bob {
+			t.Errorf("Expected bob client only, got %d clients", len(clients))
+		}
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clients := hub.recipientClients(routedMessage{session: sessionKey("instance1", "1"), to: tt.to})
+			if len(clients) != len(tt.expected) {
+				t.Fatalf("Expected %d clients, got %d", len(tt.expected), len(clients))
+			}
+			for i, c := range clients {
+				if c != tt.expected[i] {
+					t.Errorf("Expected client %s at position %d, got %s", tt.expected[i].User.Login, i, c.User.Login)
+				}
+			}
+		})
+	}
+}
+
+func TestHubSessionLifecycle(t *testing.T) {
+	hub := NewHub(time.Minute, 10)
+	alice := &Client{Hub: hub, send: make(chan []byte, 1), User: &models.User{Login: "alice", SessionID: "1"}}
+	bob := &Client{Hub: hub, send: make(chan []byte, 1), User: &models.User{Login: "bob", SessionID: "1"}}
+	key := sessionKey("", "1")
+
+	registerClient(hub, alice)
+	session := hub.sessions[key]
+	if session == nil {
+		t.Fatal("Expected session to be created on first join")
+	}
+	if session.State() != models.SESSION_STATE_OPEN {
+		t.Errorf("Expected session to be open, got %d", session.State())
+	}
+
+	registerClient(hub, bob)
+	if hub.sessions[key] != session {
+		t.Fatal("Expected session to be reused on later joins")
+	}
+
+	removeClient(hub, alice)
+	if !session.IdleSince.IsZero() {
+		t.Error("Expected session not to be idle while a client is connected")
+	}
+	removeClient(hub, bob)
+	if session.IdleSince.IsZero() {
+		t.Fatal("Expected session to be idle once all clients left")
+	}
+
+	hub.collectIdleSessions(session.IdleSince.Add(30 * time.Second))
+	if hub.sessions[key] != session {
+		t.Fatal("Expected session to be kept before idle timeout")
+	}
+
+	hub.collectIdleSessions(session.IdleSince.Add(time.Minute))
+	if hub.sessions[key] != nil {
+		t.Error("Expected session to be collected after idle timeout")
+	}
+	if session.State() != models.SESSION_STATE_CLOSED {
+		t.Errorf("Expected session to be closed, got %d", session.State())
+	}
+}
+
+func TestHubInstanceLimits(t *testing.T) {
+	previousInstances := config.Instances
+	config.Instances = map[string]config.InstanceConfig{
+		"small": {MaxSessions: 1, MaxConnections: 2},
+	}
+	defer func() { config.Instances = previousInstances }()
+
+	hub := NewHub(time.Minute, 10)
+	user := func(instanceName string, sessionID string) *models.User {
+		return &models.User{Login: "user", SessionID: sessionID, InstanceName: instanceName}
+	}
+	join := func(user *models.User) *Client {
+		client := &Client{Hub: hub, send: make(chan []byte, 1), User: user}
+		if err := hub.admit(user); err != nil {
+			t.Fatalf("Expected user to be admitted, got %v", err)
+		}
+		registerClient(hub, client)
+		return client
+	}
+
+	first := join(user("small", "1"))
+	if err := hub.admit(user("small", "2")); !errors.Is(err, ErrTooManySessions) {
+		t.Errorf("Expected ErrTooManySessions, got %v", err)
+	}
+	second := join(user("small", "1"))
+	if err := hub.admit(user("small", "1")); !errors.Is(err, ErrTooManyConnections) {
+		t.Errorf("Expected ErrTooManyConnections, got %v", err)
+	}
+	if err := hub.admit(user("other", "1")); err != nil {
+		t.Errorf("Expected limits of an instance not to apply to other instances, got %v", err)
+	}
+
+	removeClient(hub, first)
+	if err := hub.admit(user("small", "1")); err != nil {
+		t.Errorf("Expected a connection to be admitted once another one left, got %v", err)
+	}
+
+	removeClient(hub, second)
+	hub.collectIdleSessions(time.Now().Add(time.Minute))
+	if err := hub.admit(user("small", "2")); err != nil {
+		t.Errorf("Expected a session to be admitted once another one closed, got %v", err)
+	}
+}
+
+func TestHubInstanceIsolation(t *testing.T) {
+	hub := NewHub(time.Minute, 10)
+	go hub.Run()
+	alice := &Client{
+		Hub: hub, send: make(chan []byte, 10),
+		User: &models.User{Login: "alice", SessionID: "1", InstanceName: "instance1"},
+	}
+	mallory := &Client{
+		Hub: hub, send: make(chan []byte, 10),
+		User: &models.User{Login: "mallory", SessionID: "1", InstanceName: "instance2"},
+	}
+	_ = hub.register(alice)
+	_ = hub.register(mallory)
+
+	if hub.GetSession(alice.User.SessionKey()) == hub.GetSession(mallory.User.SessionKey()) {
+		t.Fatal("Expected instances to have their own session")
+	}
+	if users := hub.GetUsersInSession(alice.User.SessionKey()); len(users) != 1 || users[0] != alice.User {
+		t.Errorf("Expected alice to be alone in the session, got %d users", len(users))
+	}
+
+	_ = SendMessage(hub, mallory.User.SessionKey(), models.Message{Msg: "hello"})
+	if frame := string(<-mallory.send); frame != `{"seq":1,"from":{"type":0,"id":""},"to":null,"msg":"hello"}` {
+		t.Errorf("Unexpected frame %s", frame)
+	}
+	if len(alice.send) != 0 || hub.LastSeq(alice.User.SessionKey()) != 0 {
+		t.Error("Expected message of another instance not to reach alice")
+	}
+}
@@ -0,0 +1,177 @@
+package websocket
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"learnLoop/main/config"
+)
+
+// originPattern is an allowed origin, either an exact host or a "*." wildcard
+// matching the subdomains of a host, with an optional scheme
+type originPattern struct {
+	// scheme is empty if any scheme is allowed
+	scheme string
+	// host is the host with its optional port, without the "*." prefix
+	host     string
+	wildcard bool
+}
+
+// parseOriginPattern parses patterns like "https://app.example.com",
+// "app.example.com:8443" or "https://*.example.com"
+func parseOriginPattern(pattern string) (originPattern, error) {
+	parsed := originPattern{}
+	host := strings.ToLower(strings.TrimSpace(pattern))
+	if scheme, rest, ok := strings.Cut(host, "://"); ok {
+		if scheme != "http" && scheme != "https" {
+			return parsed, fmt.Errorf("invalid origin %q: scheme must be http or https", pattern)
+		}
+		parsed.scheme = scheme
+		host = rest
+	}
+	if rest, ok := strings.CutPrefix(host, "*."); ok {
+		parsed.wildcard = true
+		host = rest
+	}
+	if host == "" || strings.ContainsAny(host, "/*?#@") {
+		return parsed, fmt.Errorf("invalid origin %q: expected a host like app.example.com or *.example.com", pattern)
+	}
+	parsed.host = host
+	return parsed, nil
+}
+
+// match tells whether the origin matches the pattern. A wildcard matches the
+// subdomains of its host but not the host itself.
+func (p originPattern) match(origin *url.URL) bool {
+	if p.scheme != "" && p.scheme != origin.Scheme {
+		return false
+	}
+	host := strings.ToLower(origin.Host)
+	if p.wildcard {
+		prefix, ok := strings.CutSuffix(host, "."+p.host)
+		return ok && prefix != ""
+	}
+	return host == p.host
+}
+
+// originPolicy is the loaded origin allowlist
+type originPolicy struct {
+	allowed   []originPattern
+	instances map[string][]originPattern
+}
+
+var (
+	currentOriginPolicy    = &originPolicy{}
+	currentOriginPolicyMux sync.RWMutex
+)
+
+func parseOriginPatterns(patterns []string) ([]originPattern, error) {
+	parsed := make([]originPattern, 0, len(patterns))
+	for _, pattern := range patterns {
+		originPattern, err := parseOriginPattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, originPattern)
+	}
+	return parsed, nil
+}
+
+// LoadOriginPolicy replaces the origins allowed to open a connection. The
+// allowed origins of an instance replace the global ones for its users.
+func LoadOriginPolicy(allowed []string, instances map[string]config.InstanceConfig) error {
+	policy := &originPolicy{instances: make(map[string][]originPattern)}
+	var err error
+	if policy.allowed, err = parseOriginPatterns(allowed); err != nil {
+		return err
+	}
+	for instanceName, instance := range instances {
+		if instance.AllowedOrigins == nil {
+			continue
+		}
+		if policy.instances[instanceName], err = parseOriginPatterns(instance.AllowedOrigins); err != nil {
+			return fmt.Errorf("instance %s: %w", instanceName, err)
+		}
+	}
+
+	currentOriginPolicyMux.Lock()
+	defer currentOriginPolicyMux.Unlock()
+	currentOriginPolicy = policy
+	return nil
+}
+
+func getOriginPolicy() *originPolicy {
+	currentOriginPolicyMux.RLock()
+	defer currentOriginPolicyMux.RUnlock()
+	return currentOriginPolicy
+}
+
+// requestOrigin returns the origin of the page opening a connection, nil if
+// it needs no check. Requests without Origin header, which do not come from
+// a browser, and same origin requests need no check, as any origin in dev
+// mode.
+func requestOrigin(r *http.Request) (*url.URL, error) {
+	header := r.Header.Get("Origin")
+	if header == "" || config.DevMode {
+		return nil, nil
+	}
+	origin, err := url.Parse(header)
+	if err != nil || origin.Host == "" {
+		return nil, fmt.Errorf("invalid origin %q", header)
+	}
+	if strings.EqualFold(origin.Host, r.Host) {
+		return nil, nil
+	}
+	return origin, nil
+}
+
+func matchAny(patterns []originPattern, origin *url.URL) bool {
+	for _, pattern := range patterns {
+		if pattern.match(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkKnownOrigin checks that the page opening a connection is allowed for
+// at least one instance, globally or by the origins of an instance. It is
+// checked before the user is authenticated, so that a page of an unknown
+// origin cannot redeem a connect ticket or have tokens verified.
+func checkKnownOrigin(r *http.Request) error {
+	origin, err := requestOrigin(r)
+	if err != nil || origin == nil {
+		return err
+	}
+	policy := getOriginPolicy()
+	if matchAny(policy.allowed, origin) {
+		return nil
+	}
+	for _, patterns := range policy.instances {
+		if matchAny(patterns, origin) {
+			return nil
+		}
+	}
+	return fmt.Errorf("origin %s is not allowed", origin)
+}
+
+// checkOrigin checks that the page opening a connection for a user of the
+// instance is allowed to
+func checkOrigin(r *http.Request, instanceName string) error {
+	origin, err := requestOrigin(r)
+	if err != nil || origin == nil {
+		return err
+	}
+	policy := getOriginPolicy()
+	patterns, ok := policy.instances[instanceName]
+	if !ok {
+		patterns = policy.allowed
+	}
+	if !matchAny(patterns, origin) {
+		return fmt.Errorf("origin %s is not allowed for instance %s", origin, instanceName)
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package websocket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"learnLoop/main/auth"
+	"learnLoop/main/config"
+)
+
+func loadOriginPolicy(t *testing.T, allowed []string, instances map[string]config.InstanceConfig) {
+	t.Helper()
+	if err := LoadOriginPolicy(allowed, instances); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	t.Cleanup(func() { _ = LoadOriginPolicy(nil, nil) })
+}
+
+func TestCheckOrigin(t *testing.T) {
+	loadOriginPolicy(t,
+		[]string{"https://app.example.com", "*.learnloop.io", "localhost:3000"},
+		map[string]config.InstanceConfig{
+			"acme":   {AllowedOrigins: []string{"https://*.acme.com"}},
+			"closed": {AllowedOrigins: []string{}},
+			"shared": {},
+		},
+	)
+
+	tests := []struct {
+		name     string
+		origin   string
+		instance string
+		devMode  bool
+		wantErr  bool
+	}{
+		{"No origin", "", "instance1", false, false},
+		{"Same origin", "http://server.local", "instance1", false, false},
+		{"Exact origin", "https://app.example.com", "instance1", false, false},
+		{"Exact origin with another scheme", "http://app.example.com", "instance1", false, true},
+		{"Exact origin with another port", "https://app.example.com:8443", "instance1", false, true},
+		{"Subdomain of an exact origin", "https://evil.app.example.com", "instance1", false, true},
+		{"Host in another case", "https://APP.example.com", "instance1", false, false},
+		{"Wildcard subdomain", "https://eu.learnloop.io", "instance1", false, false},
+		{"Wildcard nested subdomain", "http://a.b.learnloop.io", "instance1", false, false},
+		{"Wildcard apex", "https://learnloop.io", "instance1", false, true},
+		{"Wildcard suffix trick", "https://evillearnloop.io", "instance1", false, true},
+		{"Host with port", "http://localhost:3000", "instance1", false, false},
+		{"Unknown origin", "https://attacker.com", "instance1", false, true},
+		{"Null origin", "null", "instance1", false, true},
+		{"Unknown origin in dev mode", "https://attacker.com", "instance1", true, false},
+		{"Instance origin", "https://school.acme.com", "acme", false, false},
+		{"Global origin for an instance with its own origins", "https://app.example.com", "acme", false, true},
+		{"Instance origin for another instance", "https://school.acme.com", "instance1", false, true},
+		{"Instance without allowed origin", "https://app.example.com", "closed", false, true},
+		{"Instance using the global origins", "https://app.example.com", "shared", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previousDevMode := config.DevMode
+			config.DevMode = tt.devMode
+			defer func() { config.DevMode = previousDevMode }()
+
+			r := httptest.NewRequest(http.MethodGet, "http://server.local/ws", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			err := checkOrigin(r, tt.instance)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestCheckKnownOrigin(t *testing.T) {
+	loadOriginPolicy(t,
+		[]string{"https://app.example.com"},
+		map[string]config.InstanceConfig{"acme": {AllowedOrigins: []string{"https://*.acme.com"}}},
+	)
+
+	tests := []struct {
+		name    string
+		origin  string
+		wantErr bool
+	}{
+		{"No origin", "", false},
+		{"Same origin", "http://server.local", false},
+		{"Global origin", "https://app.example.com", false},
+		{"Origin of an instance", "https://school.acme.com", false},
+		{"Unknown origin", "https://attacker.com", true},
+		{"Null origin", "null", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://server.local/ws", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if err := checkKnownOrigin(r); (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestLoadOriginPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		wantErr bool
+	}{
+		{"Valid origins", []string{"https://app.example.com", "*.example.com", "example.com:8080"}, false},
+		{"Unsupported scheme", []string{"ftp://example.com"}, true},
+		{"Path", []string{"https://example.com/app"}, true},
+		{"Wildcard in the middle", []string{"app.*.example.com"}, true},
+		{"Empty origin", []string{""}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := LoadOriginPolicy(tt.allowed, nil)
+			t.Cleanup(func() { _ = LoadOriginPolicy(nil, nil) })
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	err := LoadOriginPolicy(nil, map[string]config.InstanceConfig{
+		"acme": {AllowedOrigins: []string{"https://*"}},
+	})
+	if err == nil {
+		t.Error("Expected invalid origin of an instance to be rejected")
+	}
+}
+
+func TestServeWsRejectsOrigin(t *testing.T) {
+	ts := newTestServer(t)
+	config.DevMode = false
+	loadOriginPolicy(t, []string{"https://app.example.com"}, nil)
+	token := ts.token(t, "alice", 0)
+
+	_, resp, err := ts.dial("token="+token, http.Header{"Origin": {"https://attacker.com"}})
+	if resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected status 403, got %v", err)
+	}
+
+	conn, _, err := ts.dial("token="+token, http.Header{"Origin": {"https://app.example.com"}})
+	if err != nil {
+		t.Fatalf("Expected allowed origin to connect, got %v", err)
+	}
+	conn.Close()
+
+	// the ticket is not redeemed by a page of an unknown origin
+	identity := &auth.Identity{UserID: "bob", Login: "bob", InstanceName: "dev"}
+	ticket, _, err := connectTickets.issue(identity, time.Minute, time.Now())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	_, resp, err = ts.dial("ticket="+ticket, http.Header{"Origin": {"https://attacker.com"}})
+	if resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected status 403, got %v", err)
+	}
+	conn, _, err = ts.dial("ticket="+ticket, http.Header{"Origin": {"https://app.example.com"}})
+	if err != nil {
+		t.Fatalf("Expected ticket to be usable after a refused origin, got %v", err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,81 @@
+package websocket
+
+import (
+	"strconv"
+
+	"learnLoop/main/models"
+)
+
+// sequencedFrame is a frame sent to the recipients of a session,
+// stamped with its session sequence number.
+type sequencedFrame struct {
+	seq     uint64
+	to      []models.Recipient
+	payload []byte
+}
+
+// replayBuffer stamps the frames of a session with a monotonically
+// increasing sequence number and keeps the last frames sent, so they can be
+// replayed to reconnecting clients.
+type replayBuffer struct {
+	lastSeq  uint64
+	capacity int
+	frames   []sequencedFrame
+}
+
+func newReplayBuffer(capacity int) *replayBuffer {
+	return &replayBuffer{
+		capacity: capacity,
+		frames:   make([]sequencedFrame, 0, capacity),
+	}
+}
+
+// append stamps the payload with the next sequence number,
+// keeps it in the buffer and returns the stamped frame.
+func (b *replayBuffer) append(to []models.Recipient, payload []byte) sequencedFrame {
+	b.lastSeq++
+	frame := sequencedFrame{
+		seq:     b.lastSeq,
+		to:      to,
+		payload: stampSequence(payload, b.lastSeq),
+	}
+	if b.capacity <= 0 {
+		return frame
+	}
+	if len(b.frames) == b.capacity {
+		copy(b.frames, b.frames[1:])
+		b.frames = b.frames[:len(b.frames)-1]
+	}
+	b.frames = append(b.frames, frame)
+	return frame
+}
+
+// since returns the frames following lastSeq.
+// ok is false if some of these frames are not in the buffer anymore.
+func (b *replayBuffer) since(lastSeq uint64) (frames []sequencedFrame, ok bool) {
+	if lastSeq > b.lastSeq {
+		return nil, false
+	}
+	if lastSeq == b.lastSeq {
+		return []sequencedFrame{}, true
+	}
+	if len(b.frames) == 0 || b.frames[0].seq > lastSeq+1 {
+		return nil, false
+	}
+	start := int(lastSeq + 1 - b.frames[0].seq)
+	return b.frames[start:], true
+}
+
+// stampSequence adds the seq field to a JSON object payload
+func stampSequence(payload []byte, seq uint64) []byte {
+	if len(payload) < 2 || payload[0] != '{' {
+		return payload
+	}
+	stamped := make([]byte, 0, len(payload)+24)
+	stamped = append(stamped, `{"seq":`...)
+	stamped = strconv.AppendUint(stamped, seq, 10)
+	if payload[1] != '}' {
+		stamped = append(stamped, ',')
+	}
+	return append(stamped, payload[1:]...)
+}
@@ -0,0 +1,114 @@
+package websocket
+
+import (
+	"testing"
+	"time"
+
+	"learnLoop/main/models"
+)
+
+func TestStampSequence(t *testing.T) {
+	tests := []struct {
+		payload  string
+		expected string
+	}{
+		{`{"type":2}`, `{"seq":7,"type":2}`},
+		{`{}`, `{"seq":7}`},
+		{`[]`, `[]`},
+	}
+	for _, tt := range tests {
+		stamped := string(stampSequence([]byte(tt.payload), 7))
+		if stamped != tt.expected {
+			t.Errorf("Expected %s, got %s", tt.expected, stamped)
+		}
+	}
+}
+
+func TestReplayBuffer(t *testing.T) {
+	buffer := newReplayBuffer(3)
+	for i := 0; i < 5; i++ {
+		buffer.append(nil, []byte(`{}`))
+	}
+	if buffer.lastSeq != 5 {
+		t.Fatalf("Expected last seq 5, got %d", buffer.lastSeq)
+	}
+
+	tests := []struct {
+		name     string
+		lastSeq  uint64
+		expected []uint64
+		ok       bool
+	}{
+		{"up to date", 5, []uint64{}, true},
+		{"missed frames available", 3, []uint64{4, 5}, true},
+		{"oldest frame available", 2, []uint64{3, 4, 5}, true},
+		{"gap too large", 1, nil, false},
+		{"unknown sequence", 6, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frames, ok := buffer.since(tt.lastSeq)
+			if ok != tt.ok {
+				t.Fatalf("Expected ok %v, got %v", tt.ok, ok)
+			}
+			if len(frames) != len(tt.expected) {
+				t.Fatalf("Expected %d frames, got %d", len(tt.expected), len(frames))
+			}
+			for i, frame := range frames {
+				if frame.seq != tt.expected[i] {
+					t.Errorf("Expected seq %d, got %d", tt.expected[i], frame.seq)
+				}
+			}
+		})
+	}
+}
+
+func TestHubReplay(t *testing.T) {
+	hub := NewHub(time.Minute, 10)
+	go hub.Run()
+	alice := &Client{Hub: hub, send: make(chan []byte, 10), User: &models.User{UserID: "alice", SessionID: "1"}}
+	bob := &Client{Hub: hub, send: make(chan []byte, 10), User: &models.User{UserID: "bob", SessionID: "1"}}
+	_ = hub.register(alice)
+	_ = hub.register(bob)
+	key := alice.User.SessionKey()
+
+	_ = SendMessage(hub, key, models.Message{Msg: "to all"})
+	_ = SendMessageToRecipients(hub, key, []models.Recipient{{Type: models.RECIPIENT_TYPE_LEARNER, Id: "bob"}}, models.Message{Msg: "to bob"})
+	_ = SendMessageToUser(hub, alice.User, models.Message{Msg: "direct"})
+
+	expected := []string{
+		`{"seq":1,"from":{"type":0,"id":""},"to":null,"msg":"to all"}`,
+		`{"from":{"type":0,"id":""},"to":null,"msg":"direct"}`,
+	}
+	for _, e := range expected {
+		if frame := string(<-alice.send); frame != e {
+			t.Errorf("Expected frame %s, got %s", e, frame)
+		}
+	}
+	if hub.LastSeq(key) != 2 {
+		t.Errorf("Expected last seq 2, got %d", hub.LastSeq(key))
+	}
+
+	// alice reconnects, the frames sent to the new connection live are not
+	// replayed again
+	reconnected := &Client{Hub: hub, send: make(chan []byte, 10), User: &models.User{UserID: "alice", SessionID: "1"}}
+	_ = hub.register(reconnected)
+	live := `{"seq":3,"from":{"type":0,"id":""},"to":null,"msg":"live"}`
+	_ = SendMessage(hub, key, models.Message{Msg: "live"})
+	if frame := string(<-reconnected.send); frame != live {
+		t.Errorf("Expected live frame %s, got %s", live, frame)
+	}
+
+	if !hub.Replay(reconnected.User, 0) {
+		t.Fatal("Expected frames to be replayed")
+	}
+	if frame := string(<-reconnected.send); frame != expected[0] {
+		t.Errorf("Expected replayed frame %s, got %s", expected[0], frame)
+	}
+	if len(reconnected.send) != 0 {
+		t.Errorf("Expected only the missed frames addressed to alice to be replayed, got %d more", len(reconnected.send))
+	}
+	if hub.Replay(reconnected.User, 4) {
+		t.Error("Expected replay of unknown sequence to fail")
+	}
+}